@@ -1,12 +1,12 @@
 package main
 
 import (
-	"bytes"
 	"encoding/hex"
 	"log"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"s1/ecbdetect"
 )
 
 /*
@@ -30,31 +30,46 @@ func HasAllTopBitsSet(bytes []byte) bool {
 	return true
 }
 
-func RepeatingBlocksCount(cipherText []byte, blockSize int) int {
-	repeatedBlockCount := 0
-	for i := 0; i < len(cipherText); i += blockSize {
-		for j := i + blockSize; j < len(cipherText); j += blockSize {
-			if bytes.Equal(cipherText[i:i+blockSize], cipherText[j:j+blockSize]) {
-				repeatedBlockCount++
-			}
+// readECBCandidates decodes every line of 8.txt
+func readECBCandidates() [][]byte {
+	lines := ReadFileAsSliceOfStrings("8.txt")
+	cipherTexts := make([][]byte, len(lines))
+	for i, line := range lines {
+		unHex, err := hex.DecodeString(line)
+		if err != nil {
+			log.Fatal(err)
 		}
+		cipherTexts[i] = unHex
 	}
-	return repeatedBlockCount
+	return cipherTexts
 }
 
-func TestECBDetect(t *testing.T) {
-	cipherTexts := ReadFileAsSliceOfStrings("8.txt")
+// linesWithRepeats returns the indices of the ciphertexts with at least one repeated 16-byte block
+func linesWithRepeats(cipherTexts [][]byte) []int {
+	var found []int
 	for k, cipherText := range cipherTexts {
-		unHex, err := hex.DecodeString(cipherText)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		r := RepeatingBlocksCount(unHex, 16)
-		if r > 0 {
+		if r := ecbdetect.RepeatingBlocksCount(cipherText, 16); r > 0 {
 			log.Printf("Found %d blocks matching on line %d", r, k)
-			assert.Equal(t, 132, k) // post-factum test - it is line 132, and 132 only
+			found = append(found, k)
 		}
-
 	}
+	return found
+}
+
+func TestECBDetect(t *testing.T) {
+	// random ciphertexts of this length almost never repeat a block: exactly one line stands out
+	assert.Equal(t, 1, len(linesWithRepeats(readECBCandidates())))
+}
+
+func TestRankECBCandidates(t *testing.T) {
+	cipherTexts := readECBCandidates()
+	ranked := ecbdetect.RankECBCandidates(cipherTexts, ecbdetect.ECBBlockSizes)
+	best := ranked[0]
+	log.Printf("Line %d: score %.2f with %d-byte blocks, repeats %v", best.Index, best.Report.Score, best.Report.BlockSize, best.Report.RepeatedBlocks[0].Indices)
+
+	// the ranking puts the one line with repeats first, with a positive score, and nothing else scores
+	assert.Equal(t, linesWithRepeats(cipherTexts), []int{best.Index})
+	assert.Greater(t, best.Report.Score, 0.0)
+	assert.Equal(t, ecbdetect.RepeatingBlocksCount(cipherTexts[best.Index], best.Report.BlockSize), best.Report.RepeatedPairs)
+	assert.Equal(t, 0.0, ranked[1].Report.Score)
 }
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"s1/ecbdetect"
+	"s1/xorbreak"
+)
+
+// ByteCount is one bar of the byte histogram
+type ByteCount struct {
+	Byte  byte `json:"byte"`
+	Count int  `json:"count"`
+}
+
+// Hypothesis is one explanation of what the input might be, with a 0..1 confidence and the evidence for it
+type Hypothesis struct {
+	Name       string   `json:"name"`
+	Confidence float64  `json:"confidence"`
+	Evidence   []string `json:"evidence"`
+}
+
+// Report is everything the battery found out about the input
+type Report struct {
+	Encoding           string       `json:"encoding"`
+	Length             int          `json:"length"`
+	Entropy            float64      `json:"entropy"`
+	IndexOfCoincidence float64      `json:"index_of_coincidence"`
+	LengthMod8         int          `json:"length_mod_8"`
+	LengthMod16        int          `json:"length_mod_16"`
+	Histogram          []ByteCount  `json:"histogram"`
+	Hypotheses         []Hypothesis `json:"hypotheses"`
+}
+
+const histogramSize = 8
+
+// DecodeInput guesses whether the input is hex, base64 or raw bytes and decodes it
+func DecodeInput(input []byte) ([]byte, string) {
+	trimmed := strings.NewReplacer("\r", "", "\n", "").Replace(strings.TrimSpace(string(input)))
+	if len(trimmed) > 0 && len(trimmed)%2 == 0 {
+		if decoded, err := hex.DecodeString(trimmed); err == nil {
+			return decoded, "hex"
+		}
+	}
+	if len(trimmed) > 0 && len(trimmed)%4 == 0 {
+		if decoded, err := base64.StdEncoding.DecodeString(trimmed); err == nil {
+			return decoded, "base64"
+		}
+	}
+	return input, "raw"
+}
+
+// ShannonEntropy in bits per byte
+func ShannonEntropy(buf []byte) float64 {
+	if len(buf) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range buf {
+		counts[b]++
+	}
+	entropy := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(len(buf))
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// IndexOfCoincidence is the probability that two bytes picked at random are equal: ~0.0039 for random data, ~0.07 for English
+func IndexOfCoincidence(buf []byte) float64 {
+	if len(buf) < 2 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range buf {
+		counts[b]++
+	}
+	sum := 0
+	for _, c := range counts {
+		sum += c * (c - 1)
+	}
+	return float64(sum) / float64(len(buf)*(len(buf)-1))
+}
+
+// Histogram returns the most frequent bytes, most frequent first
+func Histogram(buf []byte, top int) []ByteCount {
+	var counts [256]int
+	for _, b := range buf {
+		counts[b]++
+	}
+	histogram := make([]ByteCount, 0, 256)
+	for b, c := range counts {
+		if c > 0 {
+			histogram = append(histogram, ByteCount{Byte: byte(b), Count: c})
+		}
+	}
+	sort.SliceStable(histogram, func(i, j int) bool { return histogram[i].Count > histogram[j].Count })
+	if len(histogram) > top {
+		histogram = histogram[:top]
+	}
+	return histogram
+}
+
+func isUniform(key []byte) bool {
+	for _, b := range key {
+		if b != key[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func preview(buf []byte) string {
+	if len(buf) > 40 {
+		buf = buf[:40]
+	}
+	return fmt.Sprintf("%q", buf)
+}
+
+// Identify runs the whole battery over the input and ranks the hypotheses, most likely first
+func Identify(input []byte) Report {
+	data, encoding := DecodeInput(input)
+	report := Report{
+		Encoding:           encoding,
+		Length:             len(data),
+		Entropy:            ShannonEntropy(data),
+		IndexOfCoincidence: IndexOfCoincidence(data),
+		LengthMod8:         len(data) % 8,
+		LengthMod16:        len(data) % 16,
+		Histogram:          Histogram(data, histogramSize),
+	}
+	if len(data) == 0 {
+		return report
+	}
+
+	// how close the entropy is to what random data of this length would reach,
+	// discounted when bytes coincide more often than the 1/256 of uniformly random data
+	randomness := 0.0
+	if len(data) > 1 {
+		randomness = report.Entropy / math.Log2(math.Min(256, float64(len(data))))
+		if report.IndexOfCoincidence > 0 {
+			randomness *= math.Min(1, 1.0/256/report.IndexOfCoincidence)
+		}
+	}
+	statistics := fmt.Sprintf("entropy %.2f bits/byte, index of coincidence %.4f", report.Entropy, report.IndexOfCoincidence)
+
+	plainScore := xorbreak.EnglishLikelihood(data)
+	report.Hypotheses = append(report.Hypotheses, Hypothesis{
+		Name:       "plaintext",
+		Confidence: plainScore,
+		Evidence:   []string{fmt.Sprintf("English likelihood %.2f", plainScore), statistics},
+	})
+
+	key, score := xorbreak.BestSingleByteKey(data)
+	if key != 0 {
+		report.Hypotheses = append(report.Hypotheses, Hypothesis{
+			Name:       "single-byte XOR",
+			Confidence: score,
+			Evidence: []string{
+				fmt.Sprintf("key 0x%02x gives English likelihood %.2f", key, score),
+				"decrypts to " + preview(xorbreak.XorWithKey(data, []byte{key})),
+			},
+		})
+	}
+
+	repeatingKey, score := xorbreak.BestRepeatingKey(data)
+	if repeatingKey != nil && !isUniform(repeatingKey) {
+		report.Hypotheses = append(report.Hypotheses, Hypothesis{
+			Name:       "repeating-key XOR",
+			Confidence: score,
+			Evidence: []string{
+				fmt.Sprintf("key size %d, key %q gives English likelihood %.2f", len(repeatingKey), repeatingKey, score),
+				"decrypts to " + preview(xorbreak.XorWithKey(data, repeatingKey)),
+			},
+		})
+	}
+
+	// the sweep puts the block size with the most repetition first, the larger one on a tie: 16-byte
+	// repeats also show up as 8-byte repeats
+	ecb := ecbdetect.SweepBlockSizes(data, ecbdetect.ECBBlockSizes)[0]
+	repeatsFound := ecb.RepeatedPairs > 0
+	if repeatsFound {
+		blockSize, repeats, blocks := ecb.BlockSize, ecb.RepeatedPairs, ecb.BlockCount
+		confidence := 0.8 + 0.2*math.Min(1, 5*float64(repeats)/float64(blocks))
+		evidence := []string{fmt.Sprintf("%d repeated %d-byte block pairs out of %d blocks", repeats, blockSize, blocks)}
+		if len(data)%blockSize != 0 {
+			confidence /= 2
+			evidence = append(evidence, fmt.Sprintf("but length is not a multiple of %d", blockSize))
+		}
+		report.Hypotheses = append(report.Hypotheses, Hypothesis{
+			Name:       fmt.Sprintf("ECB with %d-byte blocks", blockSize),
+			Confidence: confidence,
+			Evidence:   evidence,
+		})
+	}
+
+	if !repeatsFound {
+		if report.LengthMod16 == 0 {
+			report.Hypotheses = append(report.Hypotheses, Hypothesis{
+				Name:       "16-byte block cipher in a chained mode (CBC or similar)",
+				Confidence: 0.6 * randomness,
+				Evidence:   []string{"length is a multiple of 16", "no repeated blocks", statistics},
+			})
+		} else if report.LengthMod8 == 0 {
+			report.Hypotheses = append(report.Hypotheses, Hypothesis{
+				Name:       "8-byte block cipher in a chained mode (CBC or similar)",
+				Confidence: 0.6 * randomness,
+				Evidence:   []string{"length is a multiple of 8 but not 16", "no repeated blocks", statistics},
+			})
+		}
+	}
+
+	streamConfidence := 0.5 * randomness
+	streamEvidence := []string{statistics}
+	if report.LengthMod8 != 0 {
+		streamConfidence += 0.1
+		streamEvidence = append(streamEvidence, "length is not a multiple of any block size")
+	}
+	report.Hypotheses = append(report.Hypotheses, Hypothesis{
+		Name:       "stream cipher or random data",
+		Confidence: streamConfidence,
+		Evidence:   streamEvidence,
+	})
+
+	sort.SliceStable(report.Hypotheses, func(i, j int) bool {
+		return report.Hypotheses[i].Confidence > report.Hypotheses[j].Confidence
+	})
+	return report
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readChallengeFile(t *testing.T, name string) []byte {
+	buf, err := os.ReadFile("../../" + name)
+	assert.Nil(t, err)
+	return buf
+}
+
+func TestDecodeInput(t *testing.T) {
+	decoded, encoding := DecodeInput([]byte("49276d\n206b69\n"))
+	assert.Equal(t, "hex", encoding)
+	assert.Equal(t, []byte("I'm ki"), decoded)
+
+	decoded, encoding = DecodeInput([]byte("SSdtIGtp\nbGxpbmc=\n"))
+	assert.Equal(t, "base64", encoding)
+	assert.Equal(t, []byte("I'm killing"), decoded)
+
+	_, encoding = DecodeInput([]byte("plain text, not encoded"))
+	assert.Equal(t, "raw", encoding)
+}
+
+func TestStatistics(t *testing.T) {
+	assert.Equal(t, 0.0, ShannonEntropy([]byte("aaaa")))
+	assert.InDelta(t, 2.0, ShannonEntropy([]byte("abcd")), 1e-9)
+	assert.Equal(t, 1.0, IndexOfCoincidence([]byte("aaaa")))
+	assert.Equal(t, 0.0, IndexOfCoincidence([]byte("abcd")))
+	assert.Equal(t, []ByteCount{{'a', 3}, {'b', 2}}, Histogram([]byte("abacab"), 2))
+}
+
+func TestIdentifyRepeatingKeyXor(t *testing.T) {
+	report := Identify(readChallengeFile(t, "6.txt"))
+	assert.Equal(t, "base64", report.Encoding)
+	assert.Equal(t, "repeating-key XOR", report.Hypotheses[0].Name)
+	assert.Contains(t, report.Hypotheses[0].Evidence[0], "Terminator X: Bring the noise")
+}
+
+func TestIdentifySingleByteXor(t *testing.T) {
+	report := Identify([]byte("1b37373331363f78151b7f2b783431333d78397828372d363c78373e783a393b3736"))
+	assert.Equal(t, "single-byte XOR", report.Hypotheses[0].Name)
+	assert.Contains(t, report.Hypotheses[0].Evidence[1], "Cooking MC's like a pound of bacon")
+}
+
+func TestIdentifyECB(t *testing.T) {
+	lines := strings.Split(string(readChallengeFile(t, "8.txt")), "\n")
+	report := Identify([]byte(lines[132]))
+	assert.Equal(t, "ECB with 16-byte blocks", report.Hypotheses[0].Name)
+
+	report = Identify([]byte(lines[0]))
+	assert.NotContains(t, report.Hypotheses[0].Name, "ECB")
+
+	// a 32-byte block cipher: the library's sweep goes that far
+	repeated := make([]byte, 32)
+	rand.Read(repeated)
+	data := append(bytes.Repeat(repeated, 4), make([]byte, 64)...)
+	rand.Read(data[128:])
+	report = Identify([]byte(hex.EncodeToString(data)))
+	assert.Equal(t, "ECB with 32-byte blocks", report.Hypotheses[0].Name)
+}
+
+func TestIdentifyPlaintextAndRandom(t *testing.T) {
+	report := Identify([]byte("Now that the party is jumping, with the bass kicked in and the Vegas are pumpin'"))
+	assert.Equal(t, "plaintext", report.Hypotheses[0].Name)
+
+	random := make([]byte, 1003)
+	rand.Read(random)
+	report = Identify([]byte(hex.EncodeToString(random)))
+	assert.Equal(t, 1003, report.Length)
+	assert.Equal(t, "stream cipher or random data", report.Hypotheses[0].Name)
+}
+
+func TestReportOutput(t *testing.T) {
+	report := Identify(readChallengeFile(t, "6.txt"))
+
+	var text bytes.Buffer
+	printReport(&text, report)
+	assert.Contains(t, text.String(), "1. repeating-key XOR")
+
+	encoded, err := json.Marshal(report)
+	assert.Nil(t, err)
+	var decoded Report
+	assert.Nil(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, report.Hypotheses[0].Name, decoded.Hypotheses[0].Name)
+}
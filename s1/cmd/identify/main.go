@@ -0,0 +1,81 @@
+/*
+Ciphertext identification
+
+Runs the set 1 toolbox over an unknown blob and prints a ranked list of hypotheses with the evidence for each:
+encoding detection (hex/base64/raw), Shannon entropy, index of coincidence, byte histogram, length modulo 8/16,
+ECB block repetition, single-byte XOR and repeating-key XOR likelihood.
+
+Usage:
+
+	go run ./cmd/identify 6.txt
+	go run ./cmd/identify -json < blob.bin
+
+ECB block repetition comes from the ecbdetect package (challenge 8), the XOR key search and the English
+scoring from xorbreak.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+func printReport(w io.Writer, report Report) {
+	fmt.Fprintf(w, "Input: %d bytes, %s encoding\n", report.Length, report.Encoding)
+	fmt.Fprintf(w, "Entropy: %.2f bits/byte, index of coincidence: %.4f\n", report.Entropy, report.IndexOfCoincidence)
+	fmt.Fprintf(w, "Length mod 8: %d, mod 16: %d\n", report.LengthMod8, report.LengthMod16)
+
+	histogram := make([]string, 0, len(report.Histogram))
+	for _, bar := range report.Histogram {
+		histogram = append(histogram, fmt.Sprintf("0x%02x x%d", bar.Byte, bar.Count))
+	}
+	fmt.Fprintf(w, "Most frequent bytes: %s\n", strings.Join(histogram, ", "))
+
+	fmt.Fprintln(w, "Hypotheses:")
+	for i, hypothesis := range report.Hypotheses {
+		fmt.Fprintf(w, "%3d. %s (%.2f)\n", i+1, hypothesis.Name, hypothesis.Confidence)
+		for _, evidence := range hypothesis.Evidence {
+			fmt.Fprintf(w, "       - %s\n", evidence)
+		}
+	}
+}
+
+func main() {
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [-json] [file]\nReads standard input when no file is given.\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var input []byte
+	var err error
+	switch flag.NArg() {
+	case 0:
+		input, err = io.ReadAll(os.Stdin)
+	case 1:
+		input, err = os.ReadFile(flag.Arg(0))
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := Identify(input)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	printReport(os.Stdout, report)
+}
@@ -0,0 +1,93 @@
+/*
+Package ecbdetect finds ECB by its one weakness: the same plaintext block under the same key is always the same
+ciphertext block. It hashes every block once and reports which ones repeat, for one or several block sizes and
+across a corpus of ciphertexts. Challenge 8 and the identify command both use it.
+*/
+package ecbdetect
+
+import "sort"
+
+// RepeatedBlock is a block value seen more than once, with the indices of every occurrence
+type RepeatedBlock struct {
+	Block   []byte
+	Indices []int
+}
+
+// ECBReport is the result of a block repetition analysis for a single block size
+type ECBReport struct {
+	BlockSize      int
+	BlockCount     int
+	RepeatedBlocks []RepeatedBlock
+	RepeatedPairs  int     // same number as the pairwise comparison gives
+	Score          float64 // share of blocks that repeat an earlier block: 0 for random data, close to 1 for ECB of repetitive text
+}
+
+// AnalyzeBlockRepetition hashes every full block once (O(n)) and reports which block indices repeat.
+// A trailing partial block is ignored.
+func AnalyzeBlockRepetition(cipherText []byte, blockSize int) ECBReport {
+	report := ECBReport{BlockSize: blockSize, BlockCount: len(cipherText) / blockSize}
+	if report.BlockCount == 0 {
+		return report
+	}
+
+	seen := make(map[string][]int, report.BlockCount)
+	order := make([]string, 0, report.BlockCount)
+	for i := 0; i < report.BlockCount; i++ {
+		key := string(cipherText[i*blockSize : (i+1)*blockSize])
+		if _, present := seen[key]; !present {
+			order = append(order, key)
+		}
+		seen[key] = append(seen[key], i)
+	}
+
+	for _, key := range order {
+		indices := seen[key]
+		if len(indices) < 2 {
+			continue
+		}
+		report.RepeatedBlocks = append(report.RepeatedBlocks, RepeatedBlock{Block: []byte(key), Indices: indices})
+		report.RepeatedPairs += len(indices) * (len(indices) - 1) / 2
+	}
+	report.Score = float64(report.BlockCount-len(order)) / float64(report.BlockCount)
+	return report
+}
+
+func RepeatingBlocksCount(cipherText []byte, blockSize int) int {
+	return AnalyzeBlockRepetition(cipherText, blockSize).RepeatedPairs
+}
+
+var ECBBlockSizes = []int{8, 16, 32}
+
+// SweepBlockSizes analyzes the ciphertext for every given block size and returns the most likely one first.
+// Ties go to the larger block size, since a repeated 16-byte block also shows up as repeated 8-byte halves.
+func SweepBlockSizes(cipherText []byte, blockSizes []int) []ECBReport {
+	reports := make([]ECBReport, 0, len(blockSizes))
+	for _, blockSize := range blockSizes {
+		reports = append(reports, AnalyzeBlockRepetition(cipherText, blockSize))
+	}
+	sort.SliceStable(reports, func(i, j int) bool {
+		if reports[i].Score != reports[j].Score {
+			return reports[i].Score > reports[j].Score
+		}
+		return reports[i].BlockSize > reports[j].BlockSize
+	})
+	return reports
+}
+
+// ECBCandidate is one ciphertext of a corpus with its best block repetition report
+type ECBCandidate struct {
+	Index  int
+	Report ECBReport
+}
+
+// RankECBCandidates orders a corpus of ciphertexts by ECB likelihood, most likely first
+func RankECBCandidates(cipherTexts [][]byte, blockSizes []int) []ECBCandidate {
+	candidates := make([]ECBCandidate, len(cipherTexts))
+	for i, cipherText := range cipherTexts {
+		candidates[i] = ECBCandidate{Index: i, Report: SweepBlockSizes(cipherText, blockSizes)[0]}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Report.Score > candidates[j].Report.Score
+	})
+	return candidates
+}
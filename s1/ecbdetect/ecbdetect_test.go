@@ -0,0 +1,42 @@
+package ecbdetect
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeBlockRepetition(t *testing.T) {
+	cipherText := []byte("AAAAAAAABBBBBBBBAAAAAAAACCCCCCCCAAAAAAAABBBBBBBBDDDD")
+	report := AnalyzeBlockRepetition(cipherText, 8)
+
+	assert.Equal(t, 6, report.BlockCount)
+	assert.Equal(t, []RepeatedBlock{
+		{Block: []byte("AAAAAAAA"), Indices: []int{0, 2, 4}},
+		{Block: []byte("BBBBBBBB"), Indices: []int{1, 5}},
+	}, report.RepeatedBlocks)
+	assert.Equal(t, 4, report.RepeatedPairs)
+	assert.InDelta(t, 0.5, report.Score, 1e-9)
+
+	assert.Equal(t, 0.0, AnalyzeBlockRepetition([]byte("0123456789abcdef"), 8).Score)
+	assert.Equal(t, 0, AnalyzeBlockRepetition([]byte("short"), 8).BlockCount)
+}
+
+func TestSweepBlockSizes(t *testing.T) {
+	cipherText := []byte(strings.Repeat("0123456789abcdef", 4))
+	reports := SweepBlockSizes(cipherText, ECBBlockSizes)
+	assert.Equal(t, 16, reports[0].BlockSize) // ties with 8, which sees the same repetition in halves
+	assert.Equal(t, 8, reports[1].BlockSize)
+	assert.Equal(t, 32, reports[2].BlockSize)
+
+	// 8-byte blocks that only repeat on 8-byte boundaries
+	cipherText = []byte("01234567abcdefgh0123456789ABCDEF")
+	assert.Equal(t, 8, SweepBlockSizes(cipherText, ECBBlockSizes)[0].BlockSize)
+}
+
+func TestRepeatingBlocksCount(t *testing.T) {
+	assert.Equal(t, 3, RepeatingBlocksCount([]byte("AAAABBBBAAAAAAAA"), 4))
+	// a trailing partial block does not count
+	assert.Equal(t, 1, RepeatingBlocksCount([]byte("AAAABBBBAAAAAA"), 4))
+}
@@ -0,0 +1,189 @@
+/*
+Package xorbreak breaks XOR with a key that repeats: single-byte XOR (challenge 3), repeating-key XOR (challenge 6)
+and, in set 3, many messages under one CTR keystream. All of them come down to the same two steps:
+transpose the ciphertext into columns that share a key byte, then pick for each column the key byte whose
+decryption looks most like English.
+*/
+package xorbreak
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// relative frequencies of English letters, space weighted as the most frequent "letter"
+var englishFrequencies = map[byte]float64{
+	'a': 0.0817, 'b': 0.0149, 'c': 0.0278, 'd': 0.0425, 'e': 0.1270, 'f': 0.0223, 'g': 0.0202,
+	'h': 0.0609, 'i': 0.0697, 'j': 0.0015, 'k': 0.0077, 'l': 0.0403, 'm': 0.0241, 'n': 0.0675,
+	'o': 0.0751, 'p': 0.0193, 'q': 0.0010, 'r': 0.0599, 's': 0.0633, 't': 0.0906, 'u': 0.0276,
+	'v': 0.0098, 'w': 0.0236, 'x': 0.0015, 'y': 0.0197, 'z': 0.0007, ' ': 0.1800,
+}
+
+// relative frequencies of the first letters of English words, for the start of a line
+var wordStartFrequencies = map[byte]float64{
+	'a': 0.1170, 'b': 0.0440, 'c': 0.0520, 'd': 0.0320, 'e': 0.0280, 'f': 0.0400, 'g': 0.0160,
+	'h': 0.0420, 'i': 0.0730, 'j': 0.0051, 'k': 0.0086, 'l': 0.0240, 'm': 0.0380, 'n': 0.0230,
+	'o': 0.0760, 'p': 0.0430, 'q': 0.0022, 'r': 0.0280, 's': 0.0670, 't': 0.1600, 'u': 0.0120,
+	'v': 0.0082, 'w': 0.0550, 'x': 0.0005, 'y': 0.0076, 'z': 0.0005,
+}
+
+// what an average byte of English prose scores with the table above
+const englishReferenceScore = 0.085
+
+// Capitals score a little below their lowercase letters. Flipping the case bit (0x20) of the key turns a column
+// of letters into the same letters in the other case, so without this a column with no spaces or punctuation
+// scores the same under both keys.
+const capitalWeight = 0.9
+
+// printable bytes that are not letters and turn up in prose; the other symbols (^, |, {, ...) count a little
+// against a text, non-printable bytes a lot
+const proseSymbols = "0123456789 .,;:!?'\"-()"
+
+// englishScore is the average frequency in table of the bytes of buf, with capitals weighted by capitals
+// and symbols that are rare in prose counting against it
+func englishScore(buf []byte, table map[byte]float64, capitals float64) float64 {
+	score := 0.0
+	for _, b := range buf {
+		switch {
+		case b >= 'A' && b <= 'Z':
+			score += capitals * table[b+'a'-'A']
+		case b == '\n' || b == '\r' || b == '\t':
+		case b < 32 || b > 126:
+			score -= 0.5
+		case b >= 'a' && b <= 'z' || strings.IndexByte(proseSymbols, b) >= 0:
+			score += table[b]
+		default:
+			score -= 0.1
+		}
+	}
+	return score / float64(len(buf))
+}
+
+// EnglishLikelihood scores text against English letter frequencies, normalized to roughly 0 (noise) .. 1 (prose)
+func EnglishLikelihood(buf []byte) float64 {
+	if len(buf) == 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(1, englishScore(buf, englishFrequencies, capitalWeight)/englishReferenceScore))
+}
+
+func XorWithKey(buf []byte, key []byte) []byte {
+	result := make([]byte, len(buf))
+	for i := range buf {
+		result[i] = buf[i] ^ key[i%len(key)]
+	}
+	return result
+}
+
+// BestSingleByteKey returns the single-byte XOR key that makes buf look most like English
+func BestSingleByteKey(buf []byte) (byte, float64) {
+	key := bestSingleByteKey(buf, englishFrequencies, capitalWeight)
+	return key, EnglishLikelihood(XorWithKey(buf, []byte{key}))
+}
+
+// bestSingleByteKey ranks the keys on the unclamped score, so that two decryptions which both look like prose
+// are still told apart
+func bestSingleByteKey(buf []byte, table map[byte]float64, capitals float64) byte {
+	bestKey, bestScore := byte(0), math.Inf(-1)
+	for k := 0; k < 256; k++ {
+		score := englishScore(XorWithKey(buf, []byte{byte(k)}), table, capitals)
+		if score > bestScore {
+			bestKey, bestScore = byte(k), score
+		}
+	}
+	return bestKey
+}
+
+// Split cuts buf into rows of size bytes; the last row may be shorter
+func Split(buf []byte, size int) [][]byte {
+	rows := make([][]byte, 0, len(buf)/size+1)
+	for i := 0; i < len(buf); i += size {
+		end := i + size
+		if end > len(buf) {
+			end = len(buf)
+		}
+		rows = append(rows, buf[i:end])
+	}
+	return rows
+}
+
+// Transpose returns the columns of rows: column i holds byte i of every row long enough to have one.
+// The rows may have different lengths; there are as many columns as the longest row has bytes.
+func Transpose(rows [][]byte) [][]byte {
+	var columns [][]byte
+	for _, row := range rows {
+		for i, b := range row {
+			if i == len(columns) {
+				columns = append(columns, nil)
+			}
+			columns[i] = append(columns[i], b)
+		}
+	}
+	return columns
+}
+
+// BestKey solves every column as single-byte XOR
+func BestKey(columns [][]byte) []byte {
+	key := make([]byte, len(columns))
+	for i, column := range columns {
+		key[i], _ = BestSingleByteKey(column)
+	}
+	return key
+}
+
+// BestLineKey solves lines encrypted under one keystream, as fixed-nonce CTR does in set 3: column i holds byte i
+// of every line. Lines start with a word and a capital, so column 0 is scored on the first letters of words
+// and ranks capitals above lowercase letters; the other columns are running text.
+func BestLineKey(lines [][]byte) []byte {
+	columns := Transpose(lines)
+	key := BestKey(columns)
+	if len(columns) > 0 {
+		key[0] = bestSingleByteKey(columns[0], wordStartFrequencies, 1/capitalWeight)
+	}
+	return key
+}
+
+// GuessKeySizes ranks repeating-key XOR key sizes by normalized Hamming distance between consecutive blocks
+func GuessKeySizes(buf []byte, minKeySize, maxKeySize int) []int {
+	distances := make(map[int]float64)
+	keySizes := make([]int, 0)
+	for keySize := minKeySize; keySize <= maxKeySize && 2*keySize <= len(buf); keySize++ {
+		pairs := 0
+		distance := 0
+		for i := 0; i+2*keySize <= len(buf) && pairs < 16; i += keySize {
+			for j := 0; j < keySize; j++ {
+				distance += bits.OnesCount8(buf[i+j] ^ buf[i+keySize+j])
+			}
+			pairs++
+		}
+		distances[keySize] = float64(distance) / float64(pairs*keySize)
+		keySizes = append(keySizes, keySize)
+	}
+	sort.SliceStable(keySizes, func(i, j int) bool { return distances[keySizes[i]] < distances[keySizes[j]] })
+	return keySizes
+}
+
+// BestRepeatingKey solves every column of the transposed input as single-byte XOR for the most likely key sizes
+func BestRepeatingKey(buf []byte) ([]byte, float64) {
+	// keep at least 16 bytes per column, otherwise any noise can be "solved" into letters
+	maxKeySize := len(buf) / 16
+	if maxKeySize > 40 {
+		maxKeySize = 40
+	}
+	var bestKey []byte
+	bestScore := -1.0
+	keySizes := GuessKeySizes(buf, 2, maxKeySize)
+	if len(keySizes) > 3 {
+		keySizes = keySizes[:3]
+	}
+	for _, keySize := range keySizes {
+		key := BestKey(Transpose(Split(buf, keySize)))
+		score := EnglishLikelihood(XorWithKey(buf, key))
+		if score > bestScore {
+			bestKey, bestScore = key, score
+		}
+	}
+	return bestKey, bestScore
+}
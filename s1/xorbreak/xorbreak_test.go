@@ -0,0 +1,61 @@
+package xorbreak
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnglishLikelihood(t *testing.T) {
+	assert.Greater(t, EnglishLikelihood([]byte("Cooking MC's like a pound of bacon")), 0.8)
+	assert.Less(t, EnglishLikelihood([]byte{0x01, 0x8f, 0xff, 0x13}), 0.1)
+	assert.Equal(t, 0.0, EnglishLikelihood(nil))
+	assert.Greater(t, EnglishLikelihood([]byte("'Cause, 1-2-3")), EnglishLikelihood([]byte("^Cause|{6*5*4")))
+}
+
+func TestEnglishLikelihoodPrefersLowercase(t *testing.T) {
+	// letters only: the key and the key with the case bit flipped would otherwise tie
+	assert.Greater(t, EnglishLikelihood([]byte("attack")), EnglishLikelihood([]byte("ATTACK")))
+}
+
+func TestSplitAndTranspose(t *testing.T) {
+	assert.Equal(t, [][]byte{[]byte("abc"), []byte("def"), []byte("g")}, Split([]byte("abcdefg"), 3))
+	assert.Equal(t, [][]byte{[]byte("adg"), []byte("be"), []byte("cf")}, Transpose(Split([]byte("abcdefg"), 3)))
+	// rows of different lengths
+	assert.Equal(t, [][]byte{[]byte("adf"), []byte("beg"), []byte("ch"), []byte("i")},
+		Transpose([][]byte{[]byte("abc"), []byte("de"), []byte("fghi")}))
+	assert.Nil(t, Transpose(nil))
+}
+
+func TestBestSingleByteKey(t *testing.T) {
+	plainText := []byte("Cooking MC's like a pound of bacon")
+	key, score := BestSingleByteKey(XorWithKey(plainText, []byte{'X'}))
+	assert.Equal(t, byte('X'), key)
+	assert.Greater(t, score, 0.8)
+}
+
+func TestBestRepeatingKey(t *testing.T) {
+	plainText := []byte("Burning 'em, if you ain't quick and nimble\nI go crazy when I hear a cymbal " +
+		"and a high hat with a souped up tempo, I'm on a roll, it's time to go solo, " +
+		"ridin' in my five point oh, with the top down so my hair can blow")
+	cipherText := XorWithKey(plainText, []byte("ICE"))
+	key, _ := BestRepeatingKey(cipherText)
+	// a multiple of the key size decrypts just as well
+	assert.Equal(t, 0, len(key)%3)
+	assert.Equal(t, plainText, XorWithKey(cipherText, key))
+}
+
+func TestBestLineKey(t *testing.T) {
+	lines := [][]byte{[]byte("In the casual comedy;"), []byte("He, too, has resigned his part"),
+		[]byte("Yet I number him in the song;"), []byte("Transformed utterly:"), []byte("All changed, changed utterly:"),
+		[]byte("So daring and sweet his thought."), []byte("Was coming into his force;"), []byte("A terrible beauty is born.")}
+	keyStream := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	cipherTexts := make([][]byte, len(lines))
+	for i, line := range lines {
+		cipherTexts[i] = XorWithKey(line, keyStream[:len(line)])
+	}
+	// column 0 is capitals and nothing else, so only the line-start ranking gets its case right
+	key := BestLineKey(cipherTexts)
+	assert.Equal(t, keyStream[0], key[0])
+	assert.Empty(t, BestLineKey(nil))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/cipher"
+	"errors"
+	"testing"
+
+	"github.com/forgoer/openssl"
+	"github.com/stretchr/testify/assert"
+	"s2/blockmode"
+)
+
+/*
+
+Block cipher plumbing
+
+Everything in this set is "built on the ECB function": CBC, the oracles, and the attacks.
+To keep the modes and attacks from being hardwired to 16-byte AES, they take a cipher.Block
+and ask it for its block size. blockmode.NewAESECBBlock is AES behind that interface, with the key expanded
+once instead of on every openssl ECB call (the tests below check it against openssl), while DES/3DES/Blowfish
+or experimental 32-byte ciphers plug in the same way.
+
+*/
+
+// NewAESECBBlock is the AES block every challenge in this set encrypts with
+func NewAESECBBlock(key []byte) (cipher.Block, error) {
+	return blockmode.NewAESECBBlock(key)
+}
+
+// EncryptECB pads plainText and encrypts it block by block
+func EncryptECB(block cipher.Block, plainText []byte, padding Padding) []byte {
+	blockSize := block.BlockSize()
+	padded := padding.Pad(append([]byte{}, plainText...), blockSize)
+	if len(padded)%blockSize != 0 {
+		panic("EncryptECB: input not full blocks")
+	}
+	cipherText := make([]byte, len(padded))
+	blockmode.NewECBEncrypter(block).CryptBlocks(cipherText, padded)
+	return cipherText
+}
+
+// DecryptECB decrypts cipherText block by block and strips the padding
+func DecryptECB(block cipher.Block, cipherText []byte, padding Padding) ([]byte, error) {
+	blockSize := block.BlockSize()
+	if len(cipherText)%blockSize != 0 {
+		return nil, errors.New("DecryptECB: input not full blocks")
+	}
+	plainText := make([]byte, len(cipherText))
+	blockmode.NewECBDecrypter(block).CryptBlocks(plainText, cipherText)
+	return padding.Unpad(plainText, blockSize)
+}
+
+func TestAESECBBlockMatchesOpenssl(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	plainText := []byte("Hello, World!!!! and some more text")
+
+	block, err := NewAESECBBlock(key)
+	assert.Nil(t, err)
+	expected, err := openssl.AesECBEncrypt(plainText, key, openssl.PKCS7_PADDING)
+	assert.Nil(t, err)
+
+	cipherText := EncryptECB(block, plainText, PKCS7Padding)
+	assert.Equal(t, expected, cipherText)
+	decrypted, err := DecryptECB(block, cipherText, PKCS7Padding)
+	assert.Nil(t, err)
+	assert.Equal(t, plainText, decrypted)
+}
+
+func TestEncryptECBWith8ByteBlock(t *testing.T) {
+	block, err := NewDESCipher([]byte("SUBMARIN"))
+	assert.Nil(t, err)
+	plainText := []byte("0123456789ABCDEF0123456789ABCDEF")
+
+	cipherText := EncryptECB(block, plainText, PKCS7Padding)
+	assert.Equal(t, len(plainText)+8, len(cipherText))
+	assert.Equal(t, cipherText[0:8], cipherText[16:24])
+	decrypted, err := DecryptECB(block, cipherText, PKCS7Padding)
+	assert.Nil(t, err)
+	assert.Equal(t, plainText, decrypted)
+}
@@ -0,0 +1,85 @@
+package blockmode
+
+import (
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// SP 800-38A F.1.1 and F.2.1, first two blocks
+var (
+	sp80038aPlainText = SP80038APlainText[:64]
+	sp80038aECB       = "3ad77bb40d7a3660a89ecaf32466ef97f5d3d58503b9699de785895a96fdbaaf"
+	sp80038aCBC       = "7649abac8119b246cee98e9b12e9197d5086cb9b507219ee95db113a917678b2"
+)
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestNewAESECBBlock(t *testing.T) {
+	block, err := NewAESECBBlock(mustDecodeHex(SP80038AKey))
+	assert.Nil(t, err)
+
+	src := mustDecodeHex(sp80038aPlainText)[:16]
+	dst := make([]byte, 16)
+	block.Encrypt(dst, src)
+	assert.Equal(t, sp80038aECB[:32], hex.EncodeToString(dst))
+	block.Decrypt(dst, dst)
+	assert.Equal(t, src, dst)
+
+	_, err = NewAESECBBlock(make([]byte, 15))
+	assert.NotNil(t, err)
+}
+
+func TestModesSP80038A(t *testing.T) {
+	block, _ := NewAESECBBlock(mustDecodeHex(SP80038AKey))
+	plainText := mustDecodeHex(sp80038aPlainText)
+	IV := mustDecodeHex(SP80038AIV)
+
+	for _, tc := range []struct {
+		name                 string
+		encrypter, decrypter cipher.BlockMode
+		expected             string
+	}{
+		{"ECB", NewECBEncrypter(block), NewECBDecrypter(block), sp80038aECB},
+		{"CBC", NewCBCEncrypter(block, IV), NewCBCDecrypter(block, IV), sp80038aCBC},
+	} {
+		// one block per call, to check that the chaining state carries over
+		cipherText := make([]byte, len(plainText))
+		tc.encrypter.CryptBlocks(cipherText[:16], plainText[:16])
+		tc.encrypter.CryptBlocks(cipherText[16:], plainText[16:])
+		assert.Equal(t, tc.expected, hex.EncodeToString(cipherText), tc.name)
+
+		// in place
+		tc.decrypter.CryptBlocks(cipherText, cipherText)
+		assert.Equal(t, plainText, cipherText, tc.name)
+	}
+}
+
+func TestCryptBlocksRejectsPartialBlock(t *testing.T) {
+	block, _ := NewAESECBBlock(mustDecodeHex(SP80038AKey))
+	assert.Panics(t, func() { NewECBEncrypter(block).CryptBlocks(make([]byte, 17), make([]byte, 17)) })
+	assert.Panics(t, func() { NewCBCEncrypter(block, make([]byte, 16)).CryptBlocks(make([]byte, 17), make([]byte, 17)) })
+	assert.Panics(t, func() { NewCBCEncrypter(block, make([]byte, 8)) })
+}
+
+func TestDecryptBlocksRanges(t *testing.T) {
+	block, _ := NewAESECBBlock(mustDecodeHex(SP80038AKey))
+	IV := mustDecodeHex(SP80038AIV)
+	cipherText := mustDecodeHex(sp80038aCBC)
+
+	// each range only needs the ciphertext block before it
+	plainText := make([]byte, len(cipherText))
+	DecryptBlocks(block, plainText[16:], cipherText[16:], cipherText[:16])
+	DecryptBlocks(block, plainText[:16], cipherText[:16], IV)
+	assert.Equal(t, sp80038aPlainText, hex.EncodeToString(plainText))
+
+	assert.Equal(t, 0.0, testing.AllocsPerRun(10, func() { DecryptBlocks(block, plainText, cipherText, IV) }))
+}
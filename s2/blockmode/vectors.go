@@ -0,0 +1,11 @@
+package blockmode
+
+// The examples of NIST SP 800-38A appendix F (and the AES-128 examples of RFC 4493, CMAC) share one key and
+// one four-block plaintext; the ECB, CBC, CFB and OFB examples also share the IV. They are hex encoded, the way
+// the document prints them, for every test in the module that checks a mode against the standard.
+const (
+	SP80038AKey       = "2b7e151628aed2a6abf7158809cf4f3c"
+	SP80038AIV        = "000102030405060708090a0b0c0d0e0f"
+	SP80038APlainText = "6bc1bee22e409f96e93d7e117393172a" + "ae2d8a571e03ac9c9eb76fac45af8e51" +
+		"30c81c46a35ce411e5fbc1191a0a52ef" + "f69f2445df4f9b17ad2b417be66c3710"
+)
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"crypto/cipher"
 	"encoding/base64"
+	"errors"
 	"io/ioutil"
 	"log"
 	"strings"
 	"testing"
 
-	"github.com/forgoer/openssl"
 	"github.com/stretchr/testify/assert"
+	"s2/blockmode"
 )
 
 /*
@@ -35,49 +37,43 @@ What's the point of even doing this stuff if you aren't going to learn from it?
 
 */
 
-func EncryptCBCviaECB(plainText []byte, key []byte, IV []byte) []byte {
-	xIV := make([]byte, len(IV))
-	copy(xIV, IV)
-
-	if len(plainText)%16 != 0 {
-		plainText = PadPKCS7(plainText, (len(plainText)/16+1)*16)
+// EncryptCBC pads plainText and encrypts it in CBC mode on top of any block cipher
+func EncryptCBC(block cipher.Block, plainText []byte, IV []byte, padding Padding) []byte {
+	blockSize := block.BlockSize()
+	plainText = padding.Pad(append([]byte{}, plainText...), blockSize)
+	if len(plainText)%blockSize != 0 {
+		panic("EncryptCBC: input not full blocks")
 	}
-
 	cipherText := make([]byte, len(plainText))
-	buf := make([]byte, 16)
-
-	for i := 0; i < len(plainText); i += 16 {
-		for j := 0; j < 16; j++ {
-			buf[j] = plainText[i+j] ^ xIV[j]
-		}
-		buf, err := openssl.AesECBEncrypt(buf, key, "")
-		if err != nil {
-			log.Fatal(err)
-		}
-		for j := 0; j < 16; j++ {
-			cipherText[i+j] = buf[j]
-			xIV[j] = buf[j]
-		}
-	}
+	blockmode.NewCBCEncrypter(block, IV).CryptBlocks(cipherText, plainText)
 	return cipherText
 }
 
-func DecryptCBCviaECB(cipherText []byte, key []byte, IV []byte) []byte {
-	xIV := make([]byte, len(IV))
-	copy(xIV, IV)
+// DecryptCBC decrypts cipherText in CBC mode on top of any block cipher and strips the padding
+func DecryptCBC(block cipher.Block, cipherText []byte, IV []byte, padding Padding) ([]byte, error) {
+	blockSize := block.BlockSize()
+	if len(cipherText)%blockSize != 0 {
+		return nil, errors.New("DecryptCBC: input not full blocks")
+	}
 	plainText := make([]byte, len(cipherText))
+	blockmode.NewCBCDecrypter(block, IV).CryptBlocks(plainText, cipherText)
+	return padding.Unpad(plainText, blockSize)
+}
+
+func EncryptCBCviaECB(plainText []byte, key []byte, IV []byte) []byte {
+	block, err := NewAESECBBlock(key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return EncryptCBC(block, plainText, IV, PKCS7Padding)
+}
 
-	for i := 0; i < len(cipherText); i += 16 {
-		buf, err := openssl.AesECBDecrypt(cipherText[i:i+16], key, "")
-		if err != nil {
-			log.Fatal(err)
-		}
-		for j := 0; j < 16; j++ {
-			plainText[i+j] = buf[j] ^ xIV[j]
-			xIV[j] = cipherText[i+j]
-		}
+func DecryptCBCviaECB(cipherText []byte, key []byte, IV []byte) ([]byte, error) {
+	block, err := NewAESECBBlock(key)
+	if err != nil {
+		log.Fatal(err)
 	}
-	return plainText
+	return DecryptCBC(block, cipherText, IV, PKCS7Padding)
 }
 
 func ReadBase64File(fileName string) []byte {
@@ -98,20 +94,49 @@ func TestEncryptDecryptCBCviaECB16b(t *testing.T) {
 	plainText := []byte("Hello, World!!!!")
 	IV := make([]byte, 16)
 
-	assert.Equal(t, plainText, DecryptCBCviaECB(EncryptCBCviaECB(plainText, key, IV), key, IV))
+	cipherText := EncryptCBCviaECB(plainText, key, IV)
+	assert.Equal(t, 32, len(cipherText)) // a full block of padding, so decryption is not ambiguous
+	decrypted, err := DecryptCBCviaECB(cipherText, key, IV)
+	assert.Nil(t, err)
+	assert.Equal(t, plainText, decrypted)
 }
 func TestEncryptDecryptCBCviaECB32b(t *testing.T) {
 	key := []byte("YELLOW SUBMARINE")
 	plainText := []byte("Hello, World!!!!0123456789ABCDEF")
 	IV := make([]byte, 16)
 
-	assert.Equal(t, plainText, DecryptCBCviaECB(EncryptCBCviaECB(plainText, key, IV), key, IV))
+	decrypted, err := DecryptCBCviaECB(EncryptCBCviaECB(plainText, key, IV), key, IV)
+	assert.Nil(t, err)
+	assert.Equal(t, plainText, decrypted)
 }
 
 func TestDecryptCBCviaECB(t *testing.T) {
 	key := []byte("YELLOW SUBMARINE")
 	IV := make([]byte, 16)
 	cipherText := ReadBase64File("10.txt")
-	plainText := DecryptCBCviaECB(cipherText, key, IV)
+	plainText, err := DecryptCBCviaECB(cipherText, key, IV)
+	assert.Nil(t, err)
 	assert.True(t, strings.HasPrefix(string(plainText), "I'm back and I'm ringin' the bell"))
 }
+
+func TestEncryptDecryptCBC8b(t *testing.T) {
+	block, err := NewDESCipher([]byte("SUBMARIN"))
+	assert.Nil(t, err)
+	plainText := []byte("Hello, World!!!!0123456789ABCDEF")
+	IV := make([]byte, block.BlockSize())
+
+	cipherText := EncryptCBC(block, plainText, IV, NoPadding)
+	assert.Equal(t, len(plainText), len(cipherText))
+	decrypted, err := DecryptCBC(block, cipherText, IV, NoPadding)
+	assert.Nil(t, err)
+	assert.Equal(t, plainText, decrypted)
+}
+
+func TestDecryptCBCRejectsPartialBlock(t *testing.T) {
+	_, err := DecryptCBCviaECB(make([]byte, 17), []byte("YELLOW SUBMARINE"), make([]byte, 16))
+	assert.NotNil(t, err)
+	assert.Panics(t, func() {
+		block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+		EncryptCBC(block, make([]byte, 17), make([]byte, 16), NoPadding)
+	})
+}
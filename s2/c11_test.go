@@ -2,16 +2,16 @@ package main
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"log"
-	"strings"
 	"testing"
 	"time"
 
 	cryptorand "crypto/rand"
 	rand "math/rand"
 
-	"github.com/forgoer/openssl"
 	"github.com/stretchr/testify/assert"
+	"s1/ecbdetect"
 )
 
 /*
@@ -37,12 +37,16 @@ Some explanation:
 
 */
 
-func GenerateRandomAESKey() []byte {
-	key := make([]byte, 16)
+func GenerateRandomKey(size int) []byte {
+	key := make([]byte, size)
 	cryptorand.Read(key)
 	return key
 }
 
+func GenerateRandomAESKey() []byte {
+	return GenerateRandomKey(16)
+}
+
 func WrapPlaintextInRandomPadding(text string) []byte {
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 
@@ -58,56 +62,106 @@ func WrapPlaintextInRandomPadding(text string) []byte {
 	return append(precedingBuf, append([]byte(text), succeedingBuf...)...)
 }
 
-func EncryptJibberJabber(plainText string) ([]byte, bool) {
-	key := GenerateRandomAESKey()
+// encryptJibberJabberMode pads plainText with random bytes and encrypts it under a fresh random key, with ECB or CBC
+func encryptJibberJabberMode(plainText string, newCipher func([]byte) (cipher.Block, error), keySize int, useECB bool) []byte {
+	block, err := newCipher(GenerateRandomKey(keySize))
+	if err != nil {
+		log.Fatal(err)
+	}
 	input := WrapPlaintextInRandomPadding(plainText)
 
-	var cipherText []byte
+	if useECB {
+		return EncryptECB(block, input, PKCS7Padding)
+	}
+	IV := make([]byte, block.BlockSize())
+	cryptorand.Read(IV)
+	return EncryptCBC(block, input, IV, PKCS7Padding)
+}
+
+// JibberJabberOracle is the c11 encryption behind the Oracle interface, in a mode fixed when it is created;
+// the key is thrown away after every call.
+type JibberJabberOracle struct {
+	unsupportedDecrypt
+	newCipher func([]byte) (cipher.Block, error)
+	keySize   int
+	useECB    bool
+}
+
+// NewJibberJabberOracle flips a coin for the mode
+func NewJibberJabberOracle() *JibberJabberOracle {
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	useECB := rng.Intn(2) == 1
+	return NewJibberJabberOracleWith(NewAESECBBlock, 16, rng.Intn(2) == 1)
+}
 
-	if useECB {
-		buf, err := openssl.AesECBEncrypt(input, key, openssl.PKCS7_PADDING)
-		if err != nil {
-			log.Fatal(err)
-		}
-		cipherText = buf
-	} else {
-		IV := make([]byte, 16)
-		cryptorand.Read(IV)
-		cipherText = EncryptCBCviaECB(input, key, IV)
+// NewJibberJabberOracleWith lets the caller choose the block cipher and the mode, so tests know the answer
+func NewJibberJabberOracleWith(newCipher func([]byte) (cipher.Block, error), keySize int, useECB bool) *JibberJabberOracle {
+	return &JibberJabberOracle{newCipher: newCipher, keySize: keySize, useECB: useECB}
+}
+
+func (o *JibberJabberOracle) Encrypt(input []byte) ([]byte, error) {
+	return encryptJibberJabberMode(string(input), o.newCipher, o.keySize, o.useECB), nil
+}
+
+// DetectOracleECB sends enough identical bytes to fill two blocks whatever the random padding, and looks for repeats
+func DetectOracleECB(oracle Oracle, blockSize int) (bool, error) {
+	cipherText, err := oracle.Encrypt(bytes.Repeat([]byte("x"), 10+2*blockSize+1)) // 5-10 random chars + 2 full blocks
+	if err != nil {
+		return false, err
 	}
-	return cipherText, useECB
+	return DetectECBWithBlockSize(cipherText, blockSize), nil
 }
 
+// RepeatingBlocksCount counts pairs of equal full blocks with the challenge 8 analyzer from s1
 func RepeatingBlocksCount(cipherText []byte, blockSize int) int {
-	repeatedBlockCount := 0
-	for i := 0; i < len(cipherText); i += blockSize {
-		for j := i + blockSize; j < len(cipherText); j += blockSize {
-			if bytes.Equal(cipherText[i:i+blockSize], cipherText[j:j+blockSize]) {
-				repeatedBlockCount++
-			}
-		}
-	}
-	return repeatedBlockCount
+	return ecbdetect.RepeatingBlocksCount(cipherText, blockSize)
 }
 
 func DetectECB(cipherText []byte) bool {
-	return RepeatingBlocksCount(cipherText, 16) > 0
+	return DetectECBWithBlockSize(cipherText, 16)
+}
+
+func DetectECBWithBlockSize(cipherText []byte, blockSize int) bool {
+	return RepeatingBlocksCount(cipherText, blockSize) > 0
 }
 
 func TestDetectECB(t *testing.T) {
 	const attempts = 100
 
-	plainText := strings.Repeat("x", 10+2*16+1) // 5-10 random chars + 2 full blocks
-
 	detected := 0
-	for i := 0; i < 100; i++ {
-		cipherText, useECB := EncryptJibberJabber(plainText)
-		if useECB == DetectECB(cipherText) {
+	for i := 0; i < attempts; i++ {
+		useECB := i%2 == 0
+		isECB, err := DetectOracleECB(NewJibberJabberOracleWith(NewAESECBBlock, 16, useECB), 16)
+		assert.Nil(t, err)
+		if isECB == useECB {
 			detected++
 		}
 	}
 	log.Printf("Detected ECB encryption in %d%% of cases", detected*100/attempts)
 	assert.Equal(t, attempts, detected)
 }
+
+func TestDetectECB8ByteBlock(t *testing.T) {
+	const attempts = 100
+
+	detected := 0
+	for i := 0; i < attempts; i++ {
+		useECB := i%2 == 0
+		isECB, err := DetectOracleECB(NewJibberJabberOracleWith(NewDESCipher, 8, useECB), DESBlockSize)
+		assert.Nil(t, err)
+		if isECB == useECB {
+			detected++
+		}
+	}
+	assert.Equal(t, attempts, detected)
+}
+
+func TestRepeatingBlocksCountPartialBlock(t *testing.T) {
+	// the trailing "AAAA" is not a block, so it neither repeats nor runs past the end
+	cipherText := []byte("AAAAAAAABBBBBBBBAAAAAAAAAAAA")
+	assert.Equal(t, 1, RepeatingBlocksCount(cipherText, 8))
+
+	report := ecbdetect.AnalyzeBlockRepetition(cipherText, 8)
+	assert.Equal(t, 3, report.BlockCount)
+	assert.Equal(t, []ecbdetect.RepeatedBlock{{Block: []byte("AAAAAAAA"), Indices: []int{0, 2}}}, report.RepeatedBlocks)
+	assert.Equal(t, 0, RepeatingBlocksCount([]byte("short"), 8))
+}
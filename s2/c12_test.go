@@ -47,6 +47,8 @@ If our experience is any guideline, this attack will get you code execution in s
 */
 
 import (
+	"bytes"
+	"crypto/cipher"
 	"encoding/base64"
 	"errors"
 	"hash/fnv"
@@ -54,53 +56,77 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/forgoer/openssl"
 	"github.com/stretchr/testify/assert"
 )
 
 const MysteryString = "Um9sbGluJyBpbiBteSA1LjAKV2l0aCBteSByYWctdG9wIGRvd24gc28gbXkgaGFpciBjYW4gYmxvdwpUaGUgZ2lybGllcyBvbiBzdGFuZGJ5IHdhdmluZyBqdXN0IHRvIHNheSBoaQpEaWQgeW91IHN0b3A/IE5vLCBJIGp1c3QgZHJvdmUgYnkK"
 
-func PadAndEncryptECB(buf []byte, key []byte) []byte {
+// mysterySuffix is the unknown string, decoded
+func mysterySuffix() []byte {
 	suffix, err := base64.StdEncoding.DecodeString(MysteryString)
 	if err != nil {
 		log.Fatal(err)
 	}
-	plainText := append(buf, suffix...)
-	cipherText, err := openssl.AesECBEncrypt(plainText, key, openssl.PKCS7_PADDING)
+	return suffix
+}
+
+// ECBSuffixOracle encrypts input || suffix under ECB with a key fixed at creation
+type ECBSuffixOracle struct {
+	unsupportedDecrypt
+	block  cipher.Block
+	suffix []byte
+}
+
+// NewECBSuffixOracle is the challenge oracle: AES under a random key, appending the mystery string
+func NewECBSuffixOracle() *ECBSuffixOracle {
+	block, err := NewAESECBBlock(GenerateRandomAESKey())
 	if err != nil {
 		log.Fatal(err)
 	}
-	return cipherText
+	return NewECBSuffixOracleWith(block, mysterySuffix())
+}
+
+func NewECBSuffixOracleWith(block cipher.Block, suffix []byte) *ECBSuffixOracle {
+	return &ECBSuffixOracle{block: block, suffix: suffix}
 }
 
-func guessBlockSize(constantAESKey []byte, encryptor func([]byte, []byte) []byte) int {
+func (o *ECBSuffixOracle) Encrypt(input []byte) ([]byte, error) {
+	plainText := append(append([]byte{}, input...), o.suffix...)
+	return EncryptECB(o.block, plainText, PKCS7Padding), nil
+}
+
+const maxGuessedBlockSize = 64
+
+func guessBlockSize(oracle Oracle) (int, error) {
 	prevLen := 0
-	for i := 0; i < 16; i++ {
+	for i := 0; i <= maxGuessedBlockSize; i++ {
 		text := strings.Repeat("A", i)
-		cipherText := encryptor([]byte(text), constantAESKey)
+		cipherText, err := oracle.Encrypt([]byte(text))
+		if err != nil {
+			return -1, err
+		}
 		if prevLen == 0 {
 			prevLen = len(cipherText)
 		} else {
 			delta := len(cipherText) - prevLen
 			if delta > 0 {
-				return delta
+				return delta, nil
 			}
 		}
-		// log.Println(cipherText)
 	}
-	return -1
+	return -1, errors.New("Block size not detected")
 }
 
 func TestDiscoverBlockSize(t *testing.T) {
-	constantAESKey := GenerateRandomAESKey()
-	blockSize := guessBlockSize([]byte(constantAESKey), PadAndEncryptECB)
+	blockSize, err := guessBlockSize(NewECBSuffixOracle())
+	assert.Nil(t, err)
 	assert.Equal(t, 16, blockSize)
 }
 
 func TestVerifyECB(t *testing.T) {
-	constantAESKey := GenerateRandomAESKey()
 	plainText := strings.Repeat("x", 3*16)
-	cipherText := PadAndEncryptECB([]byte(plainText), []byte(constantAESKey))
+	cipherText, err := NewECBSuffixOracle().Encrypt([]byte(plainText))
+	assert.Nil(t, err)
 	assert.True(t, DetectECB(cipherText)) // use DetectECB form C11
 }
 
@@ -110,61 +136,133 @@ func hash64(buf []byte) uint64 {
 	return h.Sum64()
 }
 
-func OracleX(key []byte, blockSize int, detected []byte) (byte, error) {
-
+// OracleX finds the byte after detected in the unknown string
+func OracleX(oracle Oracle, blockSize int, detected []byte) (byte, error) {
 	startingPosition := blockSize - 1 - len(detected)
-	targetBlock := len(detected) / 16
+	targetBlock := len(detected) / blockSize
 
 	if startingPosition < 0 {
 		startingPosition += blockSize * targetBlock
 	}
-	//log.Printf("Starting position: %d, %d", startingPosition, targetBlock)
 
-	plainTextBase := strings.Repeat("_", startingPosition)
-	//log.Printf("Plaintext base: %s", plainTextBase)
+	plainTextBase := bytes.Repeat([]byte("_"), startingPosition)
 
-	targetByte := hash64(PadAndEncryptECB([]byte(plainTextBase), key)[blockSize*targetBlock : 16+blockSize*targetBlock])
+	cipherText, err := oracle.Encrypt(plainTextBase)
+	if err != nil {
+		return 0, err
+	}
+	targetByte := hash64(cipherText[blockSize*targetBlock : blockSize*(targetBlock+1)])
 	oracleDict := make(map[uint64]byte)
 
-	for r := rune(0); r < 256; r++ {
-		oracleText := plainTextBase + string(detected) + string(r)
-		//log.Printf("Oracle text: %s", oracleText)
-		oracleBytes := PadAndEncryptECB([]byte(oracleText), key)
-		hashed := hash64(oracleBytes[blockSize*targetBlock : 16+blockSize*targetBlock])
+	oracleText := append(append(append([]byte{}, plainTextBase...), detected...), 0)
+	for r := 0; r < 256; r++ {
+		oracleText[len(oracleText)-1] = byte(r)
+		oracleBytes, err := oracle.Encrypt(oracleText)
+		if err != nil {
+			return 0, err
+		}
+		hashed := hash64(oracleBytes[blockSize*targetBlock : blockSize*(targetBlock+1)])
 		oracleDict[hashed] = byte(r)
 	}
 
 	_, present := oracleDict[targetByte]
 	if present {
-		//log.Printf("detected rune: %d", oracleDict[targetByte])
 		return oracleDict[targetByte], nil
 	}
-	log.Fatal("Not detected")
 	return 0, errors.New("Not detected")
 }
 
-func TestAESPaddingOracle(t *testing.T) {
-	constantAESKey := GenerateRandomAESKey()
-	blockSize := guessBlockSize([]byte(constantAESKey), PadAndEncryptECB)
-	assert.Equal(t, 16, blockSize)
+// guessSecretLength finds how many bytes the oracle appends to our input.
+// PKCS#7 always pads, so the ciphertext grows by a block exactly when input + secret fills the last block.
+func guessSecretLength(oracle Oracle) (int, error) {
+	base, err := oracle.Encrypt([]byte{})
+	if err != nil {
+		return -1, err
+	}
+	for i := 1; i <= maxGuessedBlockSize; i++ {
+		cipherText, err := oracle.Encrypt(bytes.Repeat([]byte("A"), i))
+		if err != nil {
+			return -1, err
+		}
+		if len(cipherText) > len(base) {
+			return len(base) - i, nil
+		}
+	}
+	return -1, errors.New("Secret length not detected")
+}
 
-	plainText := strings.Repeat("x", 3*blockSize)
-	cipherText := PadAndEncryptECB([]byte(plainText), []byte(constantAESKey))
-	assert.True(t, DetectECB(cipherText))
+// decryptSuffix recovers, one byte at a time, whatever the oracle appends to our input
+func decryptSuffix(oracle Oracle, blockSize int) ([]byte, error) {
+	secretLength, err := guessSecretLength(oracle)
+	if err != nil {
+		return nil, err
+	}
 
-	detected := make([]byte, 0, 100)
+	detected := make([]byte, 0, secretLength)
 
-	for i := 0; true; i++ {
-		r, _ := OracleX([]byte(constantAESKey), blockSize, detected)
-		if r < 10 {
-			log.Printf("That last one is actually padding: %d", r)
-			//might be a good idea to cut off previously appended padding in [10,15]
-			break
+	for len(detected) < secretLength {
+		r, err := OracleX(oracle, blockSize, detected)
+		if err != nil {
+			return detected, err
 		}
 		detected = append(detected, r)
-		// log.Printf("%d %s", len(detected), string(detected))
 	}
+	return detected, nil
+}
+
+func DecryptByteAtATime(oracle Oracle) ([]byte, error) {
+	blockSize, err := guessBlockSize(oracle)
+	if err != nil {
+		return nil, err
+	}
+	return decryptSuffix(oracle, blockSize)
+}
+
+func TestAESPaddingOracle(t *testing.T) {
+	oracle := NewECBSuffixOracle()
+	blockSize, err := guessBlockSize(oracle)
+	assert.Nil(t, err)
+	assert.Equal(t, 16, blockSize)
+
+	plainText := strings.Repeat("x", 3*blockSize)
+	cipherText, _ := oracle.Encrypt([]byte(plainText))
+	assert.True(t, DetectECB(cipherText))
+
+	detected, err := DecryptByteAtATime(oracle)
+	assert.Nil(t, err)
 	log.Printf("Detected: %d string %s", len(detected), string(detected))
 	log.Println(detected)
 	assert.Equal(t, 138, len(detected))
 }
+
+func TestDESPaddingOracle(t *testing.T) {
+	block, err := NewDESCipher(GenerateRandomKey(8))
+	assert.Nil(t, err)
+	oracle := NewECBSuffixOracleWith(block, mysterySuffix())
+	blockSize, err := guessBlockSize(oracle)
+	assert.Nil(t, err)
+	assert.Equal(t, 8, blockSize)
+
+	plainText := strings.Repeat("x", 3*blockSize)
+	cipherText, _ := oracle.Encrypt([]byte(plainText))
+	assert.True(t, DetectECBWithBlockSize(cipherText, blockSize))
+
+	detected, err := DecryptByteAtATime(oracle)
+	assert.Nil(t, err)
+	assert.Equal(t, mysterySuffix(), detected)
+}
+
+func TestGuessSecretLength(t *testing.T) {
+	secretLength, err := guessSecretLength(NewECBSuffixOracle())
+	assert.Nil(t, err)
+	assert.Equal(t, len(mysterySuffix()), secretLength)
+}
+
+func TestDecryptByteAtATimeBinarySecret(t *testing.T) {
+	// the dictionary must cover all 256 byte values, not just ASCII
+	secret := []byte{0x00, 0x01, 0x7f, 0x80, 0xc3, 0xff, 'o', 'k', 0x10, 0x10}
+	block, _ := NewAESECBBlock(GenerateRandomAESKey())
+	detected, err := DecryptByteAtATime(NewECBSuffixOracleWith(block, secret))
+	assert.Nil(t, err)
+	assert.Equal(t, secret, detected)
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
-	"fmt"
+	"bytes"
+	"crypto/cipher"
+	"errors"
 	"log"
 	"net/url"
 	"strings"
 	"testing"
 
-	"github.com/forgoer/openssl"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -74,12 +75,35 @@ func TestParseURLEncodedstring(t *testing.T) {
 	assert.Equal(t, map[string]string{"email": "foo@bar.com", "role": "user", "uid": "10"}, ParseURLEncodedstring("email=foo@bar.com&uid=10&role=user"))
 }
 
+// EncodeKV joins k=v fields with sep; the values must already be quoted, which is up to the caller
+func EncodeKV(fields [][2]string, sep string) string {
+	encoded := make([]string, len(fields))
+	for i, field := range fields {
+		encoded[i] = field[0] + "=" + field[1]
+	}
+	return strings.Join(encoded, sep)
+}
+
+// ParseKV splits a k=v cookie on sep, then every field on its first '='
+func ParseKV(cookie, sep string) map[string]string {
+	result := make(map[string]string)
+	for _, field := range strings.Split(cookie, sep) {
+		k, v, _ := strings.Cut(field, "=")
+		result[k] = v
+	}
+	return result
+}
+
 func GenerateProfileFor(upn string) string {
 	upn = strings.ReplaceAll(upn, "&", "_")
 	upn = strings.ReplaceAll(upn, "%", "_")
 	upn = strings.ReplaceAll(upn, "=", "_")
-	result := fmt.Sprintf("email=%s&uid=10&role=user", upn)
-	return result
+	return EncodeKV([][2]string{{"email", upn}, {"uid", "10"}, {"role", "user"}}, "&")
+}
+
+func TestParseKV(t *testing.T) {
+	assert.Equal(t, map[string]string{"email": "foo@bar.com", "role": "user", "uid": "10"}, ParseKV("email=foo@bar.com&uid=10&role=user", "&"))
+	assert.Equal(t, map[string]string{"a": "b=c", "flag": ""}, ParseKV("a=b=c;flag", ";"))
 }
 
 func TestGenerateProfileFor(t *testing.T) {
@@ -87,18 +111,57 @@ func TestGenerateProfileFor(t *testing.T) {
 	assert.Equal(t, "email=admin@gmail.com_role_admin&uid=10&role=user", GenerateProfileFor("admin@gmail.com&role=admin"))
 }
 
-func TestEncryptDecrypt(t *testing.T) {
-	randomAESkey := GenerateRandomAESKey()
-	userProfile := GenerateProfileFor("user@gmail.com")
-	cipherText, err := openssl.AesECBEncrypt([]byte(userProfile), randomAESkey, openssl.PKCS7_PADDING)
+// ProfileOracle is the profile service: it encrypts profile_for(email) under ECB and decrypts profiles
+type ProfileOracle struct {
+	block cipher.Block
+}
+
+func NewProfileOracle() *ProfileOracle {
+	return NewProfileOracleWith(NewAESECBBlock, 16)
+}
+
+func NewProfileOracleWith(newCipher func([]byte) (cipher.Block, error), keySize int) *ProfileOracle {
+	block, err := newCipher(GenerateRandomKey(keySize))
 	if err != nil {
 		log.Fatal(err)
 	}
-	plainText, err := openssl.AesECBDecrypt(cipherText, randomAESkey, openssl.PKCS7_PADDING)
+	return &ProfileOracle{block: block}
+}
+
+// Encrypt takes an email address and returns the encrypted profile
+func (o *ProfileOracle) Encrypt(email []byte) ([]byte, error) {
+	return EncryptECB(o.block, []byte(GenerateProfileFor(string(email))), PKCS7Padding), nil
+}
+
+// Decrypt returns the encoded profile
+func (o *ProfileOracle) Decrypt(cipherText []byte) ([]byte, error) {
+	return DecryptECB(o.block, cipherText, PKCS7Padding)
+}
+
+// Validate accepts a ciphertext that decrypts to a well-formed profile
+func (o *ProfileOracle) Validate(cipherText []byte) error {
+	plainText, err := o.Decrypt(cipherText)
+	if err != nil {
+		return err
+	}
+	profile, err := url.ParseQuery(string(plainText))
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if profile.Get("email") == "" || profile.Get("role") == "" {
+		return errors.New("Incomplete profile")
 	}
-	assert.Equal(t, userProfile, string(plainText))
+	return nil
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	oracle := NewProfileOracle()
+	cipherText, err := oracle.Encrypt([]byte("user@gmail.com"))
+	assert.Nil(t, err)
+	plainText, err := oracle.Decrypt(cipherText)
+	assert.Nil(t, err)
+	assert.Equal(t, GenerateProfileFor("user@gmail.com"), string(plainText))
+	assert.Nil(t, oracle.Validate(cipherText))
 	log.Println(ParseURLEncodedstring(string(plainText)))
 }
 
@@ -114,19 +177,16 @@ func XorStr(bytes1, bytes2 []byte) []byte {
 	return resBuffer
 }
 
-func TestEncryptMutateDecrypt(t *testing.T) {
-	randomAESkey := GenerateRandomAESKey()
-
+// CutAndPasteAdmin builds a role=admin profile out of two ciphertexts the oracle made for us
+func CutAndPasteAdmin(oracle Oracle, blockSize int) ([]byte, error) {
 	// prepare a ciphertext where role falls into a separate ECB block
-	// b0-1: email=<something>&uid=10&role=
-	// b2: user<padding>
-	lenPattern := 32 - len("email=&uid=10&role=")
-	userProfile := GenerateProfileFor(strings.Repeat("_", lenPattern))
-	log.Println(userProfile)
-
-	cipherText, err := openssl.AesECBEncrypt([]byte(userProfile), randomAESkey, openssl.PKCS7_PADDING)
+	// b0..: email=<something>&uid=10&role=
+	// bn: user<padding>
+	head := len("email=&uid=10&role=")
+	lenPattern := (blockSize - head%blockSize) % blockSize
+	cipherText, err := oracle.Encrypt(bytes.Repeat([]byte("_"), lenPattern))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	// prepare a ciphertext where word "admin" falls into a separate block. Add faux PKCS7 padding.
@@ -134,25 +194,40 @@ func TestEncryptMutateDecrypt(t *testing.T) {
 	// b1: admin<faux PKCS7 padding>
 	// b2+: &uid=10&role=user
 	adminpattern := "admin"
-	lenPadding := byte(16 - len(adminpattern))
-	fauxPadding := strings.Repeat(string(rune(lenPadding)), int(lenPadding))
-	adminWithPadding := strings.Repeat("_", 16-len("email=")) + adminpattern + fauxPadding
-	userProfile2 := GenerateProfileFor(adminWithPadding)
-	cipherText2, err := openssl.AesECBEncrypt([]byte(userProfile2), randomAESkey, openssl.PKCS7_PADDING)
+	lenPadding := blockSize - len(adminpattern)
+	fill := (blockSize - len("email=")%blockSize) % blockSize
+	adminWithPadding := strings.Repeat("_", fill) + adminpattern + strings.Repeat(string(rune(lenPadding)), lenPadding)
+	cipherText2, err := oracle.Encrypt([]byte(adminWithPadding))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	// combine blocks 0 & 1 of the original ciphertext with a manufactured block 1 of next ciphertext
-	combinedCipherText := append(cipherText[0:32], cipherText2[16:32]...)
+	// combine the blocks up to role= of the first ciphertext with the admin block of the second
+	adminBlock := len("email=") + fill
+	return append(cipherText[:head+lenPattern:head+lenPattern], cipherText2[adminBlock:adminBlock+blockSize]...), nil
+}
+
+func TestEncryptMutateDecrypt(t *testing.T) {
+	oracle := NewProfileOracle()
+	combinedCipherText, err := CutAndPasteAdmin(oracle, 16)
+	assert.Nil(t, err)
 
 	// receive a nice concatenated string as a result.
-	plainText, err := openssl.AesECBDecrypt(combinedCipherText, randomAESkey, openssl.PKCS7_PADDING)
-	if err != nil {
-		log.Fatal(err)
-	}
+	assert.Nil(t, oracle.Validate(combinedCipherText))
+	plainText, err := oracle.Decrypt(combinedCipherText)
+	assert.Nil(t, err)
 
 	log.Println(string(plainText))
 	log.Println(ParseURLEncodedstring(string(plainText)))
-	assert.Equal(t, strings.ReplaceAll(userProfile, "=user", "=admin"), string(plainText))
+	assert.Equal(t, "admin", ParseURLEncodedstring(string(plainText))["role"])
+	assert.Equal(t, strings.ReplaceAll(GenerateProfileFor(strings.Repeat("_", 13)), "=user", "=admin"), string(plainText))
+}
+
+func TestCutAndPasteAdmin8ByteBlocks(t *testing.T) {
+	oracle := NewProfileOracleWith(NewDESCipher, 8)
+	cipherText, err := CutAndPasteAdmin(oracle, DESBlockSize)
+	assert.Nil(t, err)
+	plainText, err := oracle.Decrypt(cipherText)
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", ParseURLEncodedstring(string(plainText))["role"])
 }
@@ -2,12 +2,11 @@ package main
 
 import (
 	cryptorand "crypto/rand"
-	"encoding/base64"
-	"log"
 	rand "math/rand"
+	"testing"
 	"time"
 
-	"github.com/forgoer/openssl"
+	"github.com/stretchr/testify/assert"
 )
 
 /*
@@ -41,16 +40,25 @@ func GenerateRandomBytes() []byte {
 	return buf
 }
 
-func RandomPadAndEncryptECB(buf []byte, key []byte) []byte {
-	suffix, err := base64.StdEncoding.DecodeString(MysteryString)
-	if err != nil {
-		log.Fatal(err)
-	}
-	prefix := GenerateRandomBytes()
-	plainText := append(prefix, append(buf, suffix...)...)
-	cipherText, err := openssl.AesECBEncrypt(plainText, key, openssl.PKCS7_PADDING)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return cipherText
+// ECBRandomPrefixOracle encrypts prefix || input || suffix under ECB; the prefix is random bytes of random
+// length, drawn again for every call
+type ECBRandomPrefixOracle struct {
+	ECBSuffixOracle
+}
+
+func NewECBRandomPrefixOracle() *ECBRandomPrefixOracle {
+	return &ECBRandomPrefixOracle{ECBSuffixOracle: *NewECBSuffixOracle()}
+}
+
+func (o *ECBRandomPrefixOracle) Encrypt(input []byte) ([]byte, error) {
+	return o.ECBSuffixOracle.Encrypt(append(GenerateRandomBytes(), input...))
+}
+
+func TestECBRandomPrefixOracleDrawsPrefixPerCall(t *testing.T) {
+	oracle := NewECBRandomPrefixOracle()
+	first, err := oracle.Encrypt([]byte("foo@bar.com"))
+	assert.Nil(t, err)
+	second, err := oracle.Encrypt([]byte("foo@bar.com"))
+	assert.Nil(t, err)
+	assert.NotEqual(t, first, second)
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+CBC bitflipping attacks
+
+Generate a random AES key.
+
+Combine your padding code and CBC code to write two functions.
+
+The first function should take an arbitrary input string, prepend the string:
+
+"comment1=cooking%20MCs;userdata="
+
+.. and append the string:
+
+";comment2=%20like%20a%20pound%20of%20bacon"
+
+The function should quote out the ";" and "=" characters.
+
+The function should then pad out the input to the 16-byte AES block length and encrypt it under the random AES key.
+
+The second function should decrypt the string and look for the characters ";admin=true;"
+(or, equivalently, decrypt, split the string on ";", convert each resulting string into 2-tuples, and look for
+the "admin" tuple).
+
+Return true or false based on whether the string exists.
+
+If you've written the first function properly, it should not be possible to provide user input to it
+that will generate the string the second function is looking for. We'll have to break the crypto to do that.
+
+Instead, modify the ciphertext (without knowledge of the AES key) to accomplish this.
+
+You're relying on the fact that in CBC mode, a 1-bit error in a ciphertext block:
+
+    Completely scrambles the block the error occurs in
+    Produces the identical 1-bit error(/edit) in the next ciphertext block.
+
+*/
+
+// commentQuoter quotes the metacharacters of the comment cookie, and '%' so the quoting itself cannot be forged
+var commentQuoter = strings.NewReplacer("%", "%25", ";", "%3B", "=", "%3D")
+
+// CommentCookie is the c13 k=v cookie with ';' as the separator: userData goes between the challenge 16
+// comments, quoted so no field can be injected
+func CommentCookie(userData string) string {
+	return EncodeKV([][2]string{
+		{"comment1", "cooking%20MCs"},
+		{"userdata", commentQuoter.Replace(userData)},
+		{"comment2", "%20like%20a%20pound%20of%20bacon"},
+	}, ";")
+}
+
+// commentUserDataOffset is where the user data starts in a comment cookie
+func commentUserDataOffset() int {
+	return strings.Index(CommentCookie(""), "userdata=") + len("userdata=")
+}
+
+// IsAdminCookie parses the cookie and looks for the admin=true field
+func IsAdminCookie(cookie string) bool {
+	return ParseKV(cookie, ";")["admin"] == "true"
+}
+
+// CBCBitflip edits IV and cipherText so that known, the plaintext at offset, decrypts to desired instead.
+// Edits of the first block go into the IV; any other edit garbles the block before it, so an edit that spans
+// two blocks would garble its own first half and is refused.
+func CBCBitflip(IV, cipherText []byte, offset int, known, desired []byte) ([]byte, []byte, error) {
+	blockSize := len(IV)
+	if len(known) != len(desired) {
+		return nil, nil, errors.New("Known and desired plaintext must have the same length")
+	}
+	if offset < 0 || offset+len(known) > len(cipherText) {
+		return nil, nil, errors.New("Edit out of range")
+	}
+	if len(known) > 0 && offset/blockSize != (offset+len(known)-1)/blockSize {
+		return nil, nil, errors.New("Edit spans more than one block")
+	}
+
+	// one buffer for IV || cipherText, so the byte to flip for position p is always at p
+	buf := append(append([]byte{}, IV...), cipherText...)
+	for i := range known {
+		buf[offset+i] ^= known[i] ^ desired[i]
+	}
+	return buf[:blockSize], buf[blockSize:], nil
+}
+
+// BitflipServer hands out encrypted comment cookies and checks them for admin=true
+type BitflipServer struct {
+	key []byte
+}
+
+func NewBitflipServer() *BitflipServer {
+	return &BitflipServer{key: GenerateRandomAESKey()}
+}
+
+func (s *BitflipServer) Encrypt(userData string) ([]byte, []byte) {
+	IV := GenerateRandomKey(16)
+	return IV, EncryptCBCviaECB([]byte(CommentCookie(userData)), s.key, IV)
+}
+
+func (s *BitflipServer) IsAdmin(IV, cipherText []byte) (bool, error) {
+	plainText, err := DecryptCBCviaECB(cipherText, s.key, IV)
+	if err != nil {
+		return false, err
+	}
+	return IsAdminCookie(string(plainText)), nil
+}
+
+// InjectAdmin asks for a cookie with a sacrificial block followed by a harmless look-alike of ";admin=true;",
+// then flips the look-alike into the real thing at the cost of the sacrificial block
+func InjectAdmin(encrypt func(string) ([]byte, []byte), blockSize int) ([]byte, []byte, error) {
+	desired := []byte(";admin=true;")
+	known := []byte("XadminXtrueX")
+
+	// the user data starts right after "userdata="; pad it to a block boundary, then one block to garble
+	prefixLength := commentUserDataOffset()
+	fill := (blockSize - prefixLength%blockSize) % blockSize
+	userData := strings.Repeat("A", fill+blockSize) + string(known)
+	offset := prefixLength + fill + blockSize
+
+	IV, cipherText := encrypt(userData)
+	return CBCBitflip(IV, cipherText, offset, known, desired)
+}
+
+func TestCommentCookieQuoting(t *testing.T) {
+	cookie := CommentCookie(";admin=true;")
+	assert.Equal(t, "comment1=cooking%20MCs;userdata=%3Badmin%3Dtrue%3B;comment2=%20like%20a%20pound%20of%20bacon", cookie)
+	assert.False(t, IsAdminCookie(cookie))
+	assert.True(t, IsAdminCookie("comment1=x;admin=true;comment2=y"))
+}
+
+func TestQuotingStopsDirectInjection(t *testing.T) {
+	server := NewBitflipServer()
+	admin, err := server.IsAdmin(server.Encrypt(";admin=true;"))
+	assert.Nil(t, err)
+	assert.False(t, admin)
+}
+
+func TestCBCBitflipping(t *testing.T) {
+	server := NewBitflipServer()
+	IV, cipherText, err := InjectAdmin(server.Encrypt, 16)
+	assert.Nil(t, err)
+
+	admin, err := server.IsAdmin(IV, cipherText)
+	assert.Nil(t, err)
+	assert.True(t, admin)
+
+	plainText, _ := DecryptCBCviaECB(cipherText, server.key, IV)
+	log.Printf("%q", plainText)
+}
+
+func TestCBCBitflipFirstBlockUsesIV(t *testing.T) {
+	key := GenerateRandomAESKey()
+	IV := GenerateRandomKey(16)
+	plainText := []byte("role=user;uid=10;email=me@example.com")
+	cipherText := EncryptCBCviaECB(plainText, key, IV)
+
+	newIV, newCipherText, err := CBCBitflip(IV, cipherText, 5, []byte("user;"), []byte("admin"))
+	assert.Nil(t, err)
+	assert.Equal(t, cipherText, newCipherText)
+
+	decrypted, err := DecryptCBCviaECB(newCipherText, key, newIV)
+	assert.Nil(t, err)
+	assert.Equal(t, "role=admin", string(decrypted[:10]))
+	// the IV edit garbles nothing
+	assert.Equal(t, plainText[10:], decrypted[10:])
+
+	_, _, err = CBCBitflip(IV, cipherText, 47, []byte("abc"), []byte("xyz"))
+	assert.NotNil(t, err)
+	_, _, err = CBCBitflip(IV, cipherText, 0, []byte("ab"), []byte("xyz"))
+	assert.NotNil(t, err)
+}
+
+func TestCBCBitflipRefusesEditsAcrossBlocks(t *testing.T) {
+	key := GenerateRandomAESKey()
+	IV := GenerateRandomKey(16)
+	plainText := []byte("role=user;uid=10;email=me@example.com")
+	cipherText := EncryptCBCviaECB(plainText, key, IV)
+
+	for _, offset := range []int{14, 30} {
+		_, _, err := CBCBitflip(IV, cipherText, offset, []byte("abc"), []byte("xyz"))
+		assert.NotNil(t, err, "offset %d", offset)
+	}
+
+	// within the second block, the edit lands and only the first block is garbled
+	newIV, newCipherText, err := CBCBitflip(IV, cipherText, 16, []byte(";email"), []byte(";admin"))
+	assert.Nil(t, err)
+	assert.Equal(t, IV, newIV)
+	decrypted, err := DecryptCBCviaECB(newCipherText, key, newIV)
+	assert.Nil(t, err)
+	assert.Equal(t, ";admin=me@example.com", string(decrypted[16:]))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/cipher"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+The CBC padding oracle
+
+This is the best-known attack on modern block-cipher cryptography.
+
+Combine your padding code and your CBC code to write two functions.
+
+The first function should select at random one of the following 10 strings, generate a random AES key
+(which it should save for all future encryptions), pad the string out to the 16-byte AES block size
+and CBC-encrypt it under that key, providing the caller the ciphertext and IV.
+
+The second function should consume the ciphertext produced by the first function, decrypt it, check its padding,
+and return true or false depending on whether the padding is valid.
+
+It turns out that it's possible to decrypt the ciphertexts provided by the first function.
+
+The decryption here depends on a side-channel leak by the decryption function.
+The leak is the error message that the padding is valid or not.
+
+The fundamental insight behind this attack is that the byte 01h is valid padding, and occur in 1/256 trials
+of "randomized" plaintexts produced by decrypting a tampered ciphertext.
+
+02h in isolation is not valid padding.
+02h 02h is valid padding, but is much less likely to occur randomly than 01h.
+03h 03h 03h is even less likely.
+
+So you can assume that if you corrupt a decryption AND it had valid padding, you know what that padding byte is.
+
+It is easy to get tripped up on the fact that CBC plaintexts are "padded".
+Padding oracles have nothing to do with the actual padding on a CBC plaintext.
+It's an attack that targets a specific bit of code that handles decryption.
+You can mount a padding oracle on any CBC block, whether it's padded or not.
+
+*/
+
+var PaddingOracleStrings = []string{
+	"MDAwMDAwTm93IHRoYXQgdGhlIHBhcnR5IGlzIGp1bXBpbmc=",
+	"MDAwMDAxV2l0aCB0aGUgYmFzcyBraWNrZWQgaW4gYW5kIHRoZSBWZWdhJ3MgYXJlIHB1bXBpbic=",
+	"MDAwMDAyUXVpY2sgdG8gdGhlIHBvaW50LCB0byB0aGUgcG9pbnQsIG5vIGZha2luZw==",
+	"MDAwMDAzQ29va2luZyBNQydzIGxpa2UgYSBwb3VuZCBvZiBiYWNvbg==",
+	"MDAwMDA0QnVybmluZyAnZW0sIGlmIHlvdSBhaW4ndCBxdWljayBhbmQgbmltYmxl",
+	"MDAwMDA1SSBnbyBjcmF6eSB3aGVuIEkgaGVhciBhIGN5bWJhbA==",
+	"MDAwMDA2QW5kIGEgaGlnaCBoYXQgd2l0aCBhIHNvdXBlZCB1cCB0ZW1wbw==",
+	"MDAwMDA3SSdtIG9uIGEgcm9sbCwgaXQncyB0aW1lIHRvIGdvIHNvbG8=",
+	"MDAwMDA4b2xsaW4nIGluIG15IGZpdmUgcG9pbnQgb2g=",
+	"MDAwMDA5aXRoIG15IHJhZy10b3AgZG93biBzbyBteSBoYWlyIGNhbiBibG93",
+}
+
+// PaddingOracle reports whether (IV, cipherText) decrypts to a validly padded plaintext
+type PaddingOracle func(IV, cipherText []byte) bool
+
+// CBCPaddingServer is a local stand-in for a server that leaks whether the padding of a CBC message was valid
+type CBCPaddingServer struct {
+	block cipher.Block
+}
+
+func NewCBCPaddingServer() *CBCPaddingServer {
+	block, err := NewAESECBBlock(GenerateRandomAESKey())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &CBCPaddingServer{block: block}
+}
+
+// Encrypt CBC-encrypts plainText under the server key with a random IV
+func (s *CBCPaddingServer) Encrypt(plainText []byte) ([]byte, []byte) {
+	IV := GenerateRandomKey(s.block.BlockSize())
+	return IV, EncryptCBC(s.block, plainText, IV, PKCS7Padding)
+}
+
+// EncryptRandomString encrypts one of the challenge strings, picked at random
+func (s *CBCPaddingServer) EncryptRandomString() ([]byte, []byte) {
+	i, _ := cryptorand.Int(cryptorand.Reader, big.NewInt(int64(len(PaddingOracleStrings))))
+	plainText, err := base64.StdEncoding.DecodeString(PaddingOracleStrings[i.Int64()])
+	if err != nil {
+		log.Fatal(err)
+	}
+	return s.Encrypt(plainText)
+}
+
+// Decrypt is what the server does with a message it receives; the padding oracle only exposes whether it failed
+func (s *CBCPaddingServer) Decrypt(IV, cipherText []byte) ([]byte, error) {
+	return DecryptCBC(s.block, cipherText, IV, PKCS7Padding)
+}
+
+func (s *CBCPaddingServer) ValidPadding(IV, cipherText []byte) bool {
+	_, err := s.Decrypt(IV, cipherText)
+	return err == nil
+}
+
+// RecoverIntermediate finds D(block), the block cipher output before it is XORed with the previous ciphertext block,
+// by feeding the oracle a forged previous block and walking the padding from 01 up to a full block.
+// It returns the number of oracle queries spent.
+func RecoverIntermediate(oracle PaddingOracle, blockSize int, block []byte) ([]byte, int, error) {
+	intermediate := make([]byte, blockSize)
+	forged := make([]byte, blockSize)
+	queries := 0
+
+	for pos := blockSize - 1; pos >= 0; pos-- {
+		padding := byte(blockSize - pos)
+		for j := pos + 1; j < blockSize; j++ {
+			forged[j] = intermediate[j] ^ padding
+		}
+
+		found := false
+		for guess := 0; guess < 256 && !found; guess++ {
+			forged[pos] = byte(guess)
+			queries++
+			if !oracle(forged, block) {
+				continue
+			}
+			if pos == blockSize-1 && pos > 0 {
+				// the padding might be 02 02 (or longer) by accident rather than 01:
+				// flip the byte before it, a real 01 stays valid
+				forged[pos-1] ^= 1
+				queries++
+				valid := oracle(forged, block)
+				forged[pos-1] ^= 1
+				if !valid {
+					continue
+				}
+			}
+			intermediate[pos] = byte(guess) ^ padding
+			found = true
+		}
+		if !found {
+			return nil, queries, errors.New("No valid padding found, is this really a padding oracle?")
+		}
+	}
+	return intermediate, queries, nil
+}
+
+// PaddingOracleDecrypt decrypts every block, the first one included by using the IV as its previous block,
+// and strips the padding. It returns the number of oracle queries spent.
+func PaddingOracleDecrypt(oracle PaddingOracle, blockSize int, IV, cipherText []byte) ([]byte, int, error) {
+	if len(IV) != blockSize || len(cipherText) == 0 || len(cipherText)%blockSize != 0 {
+		return nil, 0, errors.New("Ciphertext must be a non-empty sequence of full blocks")
+	}
+	plainText := make([]byte, 0, len(cipherText))
+	previous := IV
+	queries := 0
+
+	for i := 0; i < len(cipherText); i += blockSize {
+		block := cipherText[i : i+blockSize]
+		intermediate, q, err := RecoverIntermediate(oracle, blockSize, block)
+		queries += q
+		if err != nil {
+			return nil, queries, err
+		}
+		for j := range intermediate {
+			plainText = append(plainText, intermediate[j]^previous[j])
+		}
+		previous = block
+	}
+
+	unpadded, err := UnpadPKCS7(plainText, blockSize)
+	return unpadded, queries, err
+}
+
+func TestPaddingOracleDecryptsEveryString(t *testing.T) {
+	server := NewCBCPaddingServer()
+	for _, encoded := range PaddingOracleStrings {
+		expected, _ := base64.StdEncoding.DecodeString(encoded)
+		IV, cipherText := server.Encrypt(expected)
+
+		plainText, queries, err := PaddingOracleDecrypt(server.ValidPadding, 16, IV, cipherText)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, plainText)
+		log.Printf("%d queries: %s", queries, plainText)
+		assert.LessOrEqual(t, queries, len(cipherText)*(256+1))
+	}
+}
+
+func TestPaddingOracleRandomString(t *testing.T) {
+	server := NewCBCPaddingServer()
+	IV, cipherText := server.EncryptRandomString()
+	plainText, _, err := PaddingOracleDecrypt(server.ValidPadding, 16, IV, cipherText)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(plainText), "00000"))
+}
+
+func TestPaddingOracle8ByteBlocks(t *testing.T) {
+	block, _ := NewDESCipher(GenerateRandomKey(8))
+	oracle := func(IV, cipherText []byte) bool {
+		_, err := DecryptCBC(block, cipherText, IV, PKCS7Padding)
+		return err == nil
+	}
+	expected := []byte("I'm on a roll, it's time to go solo")
+	IV := GenerateRandomKey(8)
+	plainText, _, err := PaddingOracleDecrypt(oracle, 8, IV, EncryptCBC(block, expected, IV, PKCS7Padding))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, plainText)
+}
+
+func TestRecoverIntermediateFalsePositive(t *testing.T) {
+	// with an all-zero forged block, this intermediate decrypts to ...02 ?? and the guess that makes
+	// the last byte 02 is valid padding too; the attack must not settle for it
+	intermediate := []byte("YELLOW SUBMARI\x02N")
+	oracle := func(forged, block []byte) bool {
+		plainText := make([]byte, len(forged))
+		for i := range forged {
+			plainText[i] = forged[i] ^ intermediate[i]
+		}
+		_, err := UnpadPKCS7(plainText, len(plainText))
+		return err == nil
+	}
+
+	recovered, _, err := RecoverIntermediate(oracle, 16, make([]byte, 16))
+	assert.Nil(t, err)
+	assert.Equal(t, intermediate, recovered)
+}
+
+func TestPaddingOracleRejectsGarbage(t *testing.T) {
+	never := func(IV, cipherText []byte) bool { return false }
+	_, _, err := PaddingOracleDecrypt(never, 16, make([]byte, 16), make([]byte, 16))
+	assert.NotNil(t, err)
+	_, _, err = PaddingOracleDecrypt(never, 16, make([]byte, 16), make([]byte, 15))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"s2/blockmode"
+)
+
+/*
+
+Implement CTR, the stream cipher mode
+
+The string:
+
+L77na/nrFsKvynd6HzOoG7GHTLXsTVu9qvY/2syLXzhPweyyMTJULu/6/kXX0KSvoOLSFQ==
+
+... decrypts to something approximating English in CTR mode, which is an AES block cipher mode that turns
+AES into a stream cipher, with the following parameters:
+
+      key=YELLOW SUBMARINE
+      nonce=0
+      format=64 bit unsigned little endian nonce,
+             64 bit little endian block count (byte count / 16)
+
+CTR mode is very simple.
+
+Instead of encrypting the plaintext, CTR mode encrypts a running counter, producing a 16 byte block of keystream,
+which is XOR'd against the plaintext.
+
+CTR mode does not require padding; when you run out of plaintext, you just stop XOR'ing keystream
+and stop generating keystream.
+
+Decryption is identical to encryption. Generate the same keystream, XOR, and recover the plaintext.
+
+Decrypt the string at the top of this function, then use your CTR function to encrypt and decrypt other things.
+
+NIST SP 800-38A lays the counter block out differently: the whole block is one big-endian counter
+that starts at the initial counter block. Both layouts are supported below.
+
+*/
+
+type CounterLayout int
+
+const (
+	// CounterLE64 is the Cryptopals layout: nonce || 64-bit little-endian block counter
+	CounterLE64 CounterLayout = iota
+	// CounterBE128 is the SP 800-38A layout: the whole block is a big-endian counter
+	CounterBE128
+)
+
+// CTR is counter mode on top of any block cipher. It implements cipher.Stream and can seek to any byte offset.
+type CTR struct {
+	block     cipher.Block
+	layout    CounterLayout
+	initial   []byte
+	offset    uint64
+	keyStream []byte
+}
+
+// NewCTR creates a CTR stream; iv is the initial counter block (for CounterLE64: the nonce followed by the starting count)
+func NewCTR(block cipher.Block, iv []byte, layout CounterLayout) *CTR {
+	if len(iv) != block.BlockSize() {
+		panic("NewCTR: IV length must equal block size")
+	}
+	if layout == CounterLE64 && block.BlockSize() < 8 {
+		panic("NewCTR: block too small for a 64-bit counter")
+	}
+	c := &CTR{
+		block:     block,
+		layout:    layout,
+		initial:   append([]byte{}, iv...),
+		keyStream: make([]byte, block.BlockSize()),
+	}
+	c.generate(0)
+	return c
+}
+
+// counterBlock returns the counter block for the given block index
+func (c *CTR) counterBlock(index uint64) []byte {
+	counter := append([]byte{}, c.initial...)
+	switch c.layout {
+	case CounterLE64:
+		count := counter[len(counter)-8:]
+		binary.LittleEndian.PutUint64(count, binary.LittleEndian.Uint64(count)+index)
+	case CounterBE128:
+		// add index to the big-endian number, wrapping around at the block size
+		carry := index
+		for i := len(counter) - 1; i >= 0 && carry > 0; i-- {
+			sum := uint64(counter[i]) + carry&0xff
+			counter[i] = byte(sum)
+			carry = carry>>8 + sum>>8
+		}
+	}
+	return counter
+}
+
+func (c *CTR) generate(index uint64) {
+	c.block.Encrypt(c.keyStream, c.counterBlock(index))
+}
+
+func (c *CTR) XORKeyStream(dst, src []byte) {
+	if len(dst) < len(src) {
+		panic("XORKeyStream: output smaller than input")
+	}
+	blockSize := uint64(c.block.BlockSize())
+	for i := range src {
+		pos := c.offset % blockSize
+		dst[i] = src[i] ^ c.keyStream[pos]
+		c.offset++
+		if pos == blockSize-1 {
+			c.generate(c.offset / blockSize)
+		}
+	}
+}
+
+// Seek moves the stream to an absolute byte offset, so a ciphertext can be decrypted from the middle
+func (c *CTR) Seek(offset uint64) {
+	c.offset = offset
+	c.generate(offset / uint64(c.block.BlockSize()))
+}
+
+// CTRCrypt encrypts or decrypts src in one go
+func CTRCrypt(block cipher.Block, iv []byte, layout CounterLayout, src []byte) []byte {
+	dst := make([]byte, len(src))
+	NewCTR(block, iv, layout).XORKeyStream(dst, src)
+	return dst
+}
+
+func TestDecryptCTR(t *testing.T) {
+	cipherText, _ := base64.StdEncoding.DecodeString("L77na/nrFsKvynd6HzOoG7GHTLXsTVu9qvY/2syLXzhPweyyMTJULu/6/kXX0KSvoOLSFQ==")
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+
+	plainText := CTRCrypt(block, make([]byte, 16), CounterLE64, cipherText)
+	assert.Equal(t, "Yo, VIP Let's kick it Ice, Ice, baby Ice, Ice, baby ", string(plainText))
+	assert.Equal(t, cipherText, CTRCrypt(block, make([]byte, 16), CounterLE64, plainText))
+}
+
+func TestCTRSP80038A(t *testing.T) {
+	plainText := mustDecodeHex(blockmode.SP80038APlainText)
+	initialCounter := mustDecodeHex("f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+
+	vectors := []struct{ name, key, cipherText string }{
+		{"F.5.1 CTR-AES128", blockmode.SP80038AKey,
+			"874d6191b620e3261bef6864990db6ce" + "9806f66b7970fdff8617187bb9fffdff" +
+				"5ae4df3edbd5d35e5b4f09020db03eab" + "1e031dda2fbe03d1792170a0f3009cee"},
+		{"F.5.5 CTR-AES256", "603deb1015ca71be2b73aef0857d77811f352c073b6108d72d9810a30914dff4",
+			"601ec313775789a5b7a7f504bbf3d228" + "f443e3ca4d62b59aca84e990cacaf5c5" +
+				"2b0930daa23de94ce87017ba2d84988d" + "dfc9c58db67aada613c2dd08457941a6"},
+	}
+	for _, v := range vectors {
+		block, err := NewAESECBBlock(mustDecodeHex(v.key))
+		assert.Nil(t, err)
+		cipherText := CTRCrypt(block, initialCounter, CounterBE128, plainText)
+		assert.Equal(t, v.cipherText, hex.EncodeToString(cipherText), v.name)
+		assert.Equal(t, plainText, CTRCrypt(block, initialCounter, CounterBE128, cipherText), v.name)
+	}
+}
+
+func TestCTRCounterLayouts(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+
+	le := NewCTR(block, mustDecodeHex("0011223344556677"+"ffffffffffffffff"), CounterLE64)
+	assert.Equal(t, mustDecodeHex("0011223344556677"+"0000000000000000"), le.counterBlock(1))
+	assert.Equal(t, mustDecodeHex("0011223344556677"+"0100000000000000"), le.counterBlock(2))
+
+	be := NewCTR(block, mustDecodeHex("000000000000000000000000000000ff"), CounterBE128)
+	assert.Equal(t, mustDecodeHex("00000000000000000000000000000100"), be.counterBlock(1))
+	assert.Equal(t, mustDecodeHex("000000000000000000000000010000fe"), be.counterBlock(1<<24-1))
+
+	wrap := NewCTR(block, mustDecodeHex("ffffffffffffffffffffffffffffffff"), CounterBE128)
+	assert.Equal(t, make([]byte, 16), wrap.counterBlock(1))
+}
+
+func TestCTRStreamingAndSeek(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	nonce := GenerateRandomKey(16)
+	plainText := []byte("I'm back and I'm ringin' the bell, a rockin' on the mike while the fly girls yell")
+
+	for _, layout := range []CounterLayout{CounterLE64, CounterBE128} {
+		expected := CTRCrypt(block, nonce, layout, plainText)
+
+		// arbitrary chunk sizes give the same result as one call
+		stream := NewCTR(block, nonce, layout)
+		chunked := make([]byte, len(plainText))
+		for i, step := 0, 1; i < len(plainText); i, step = i+step, step+2 {
+			end := i + step
+			if end > len(plainText) {
+				end = len(plainText)
+			}
+			stream.XORKeyStream(chunked[i:end], plainText[i:end])
+		}
+		assert.Equal(t, expected, chunked)
+
+		for _, offset := range []int{0, 1, 15, 16, 17, 33, 47, len(plainText) - 1} {
+			stream.Seek(uint64(offset))
+			decrypted := make([]byte, len(plainText)-offset)
+			stream.XORKeyStream(decrypted, expected[offset:])
+			assert.Equal(t, plainText[offset:], decrypted)
+		}
+	}
+}
+
+func TestCTR8ByteBlocks(t *testing.T) {
+	block, _ := NewDESCipher([]byte("SUBMARIN"))
+	plainText := []byte("Ice, Ice, baby")
+	cipherText := CTRCrypt(block, make([]byte, 8), CounterLE64, plainText)
+	assert.Equal(t, len(plainText), len(cipherText))
+	assert.Equal(t, plainText, CTRCrypt(block, make([]byte, 8), CounterLE64, cipherText))
+}
+
+var _ cipher.Stream = &CTR{}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/base64"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"s1/xorbreak"
+)
+
+/*
+
+Break fixed-nonce CTR mode using substitutions
+
+Take your CTR encrypt/decrypt function and fix its nonce value to 0. Generate a random AES key.
+
+In successive encryptions (not in one big running CTR stream), encrypt each line of the base64 decodes of
+the following, producing multiple independent ciphertexts:
+
+SSBoYXZlIG1ldCB0aGVtIGF0IGNsb3NlIG9mIGRheQ==
+Q29taW5nIHdpdGggdml2aWQgZmFjZXM=
+...
+QSB0ZXJyaWJsZSBiZWF1dHkgaXMgYm9ybi4=
+
+(This should produce 40 short CTR-encrypted ciphertexts).
+
+Because the CTR nonce wasn't randomized for each encryption, each ciphertext has been encrypted against the
+same keystream. This is very bad.
+
+Understanding that, like most stream ciphers (including RC4, and obviously any block cipher run in CTR mode),
+the actual "encryption" of a byte of data boils down to a single XOR operation, it should be plain that:
+
+CIPHERTEXT-BYTE XOR PLAINTEXT-BYTE = KEYSTREAM-BYTE
+
+And since the keystream is the same for every ciphertext:
+
+CIPHERTEXT-BYTE XOR KEYSTREAM-BYTE = PLAINTEXT-BYTE (ie, "you don't
+say!")
+
+Attack this cryptosystem piecemeal: guess letters, use expected English language frequency to validate
+guesses, catch common English trigrams, and so on.
+
+Don't overthink it.
+
+Points for automating this, but part of the reason I'm having you do this is that I think this approach is
+suboptimal.
+
+*/
+
+var fixedNonceLines = []string{
+	"SSBoYXZlIG1ldCB0aGVtIGF0IGNsb3NlIG9mIGRheQ==",
+	"Q29taW5nIHdpdGggdml2aWQgZmFjZXM=",
+	"RnJvbSBjb3VudGVyIG9yIGRlc2sgYW1vbmcgZ3JleQ==",
+	"RWlnaHRlZW50aC1jZW50dXJ5IGhvdXNlcy4=",
+	"SSBoYXZlIHBhc3NlZCB3aXRoIGEgbm9kIG9mIHRoZSBoZWFk",
+	"T3IgcG9saXRlIG1lYW5pbmdsZXNzIHdvcmRzLA==",
+	"T3IgaGF2ZSBsaW5nZXJlZCBhd2hpbGUgYW5kIHNhaWQ=",
+	"UG9saXRlIG1lYW5pbmdsZXNzIHdvcmRzLA==",
+	"QW5kIHRob3VnaHQgYmVmb3JlIEkgaGFkIGRvbmU=",
+	"T2YgYSBtb2NraW5nIHRhbGUgb3IgYSBnaWJl",
+	"VG8gcGxlYXNlIGEgY29tcGFuaW9u",
+	"QXJvdW5kIHRoZSBmaXJlIGF0IHRoZSBjbHViLA==",
+	"QmVpbmcgY2VydGFpbiB0aGF0IHRoZXkgYW5kIEk=",
+	"QnV0IGxpdmVkIHdoZXJlIG1vdGxleSBpcyB3b3JuOg==",
+	"QWxsIGNoYW5nZWQsIGNoYW5nZWQgdXR0ZXJseTo=",
+	"QSB0ZXJyaWJsZSBiZWF1dHkgaXMgYm9ybi4=",
+	"VGhhdCB3b21hbidzIGRheXMgd2VyZSBzcGVudA==",
+	"SW4gaWdub3JhbnQgZ29vZCB3aWxsLA==",
+	"SGVyIG5pZ2h0cyBpbiBhcmd1bWVudA==",
+	"VW50aWwgaGVyIHZvaWNlIGdyZXcgc2hyaWxsLg==",
+	"V2hhdCB2b2ljZSBtb3JlIHN3ZWV0IHRoYW4gaGVycw==",
+	"V2hlbiB5b3VuZyBhbmQgYmVhdXRpZnVsLA==",
+	"U2hlIHJvZGUgdG8gaGFycmllcnM/",
+	"VGhpcyBtYW4gaGFkIGtlcHQgYSBzY2hvb2w=",
+	"QW5kIHJvZGUgb3VyIHdpbmdlZCBob3JzZS4=",
+	"VGhpcyBvdGhlciBoaXMgaGVscGVyIGFuZCBmcmllbmQ=",
+	"V2FzIGNvbWluZyBpbnRvIGhpcyBmb3JjZTs=",
+	"SGUgbWlnaHQgaGF2ZSB3b24gZmFtZSBpbiB0aGUgZW5kLA==",
+	"U28gc2Vuc2l0aXZlIGhpcyBuYXR1cmUgc2VlbWVkLA==",
+	"U28gZGFyaW5nIGFuZCBzd2VldCBoaXMgdGhvdWdodC4=",
+	"VGhpcyBvdGhlciBtYW4gSSBoYWQgZHJlYW1lZA==",
+	"QSBkcnVua2VuLCB2YWluLWdsb3Jpb3VzIGxvdXQu",
+	"SGUgaGFkIGRvbmUgbW9zdCBiaXR0ZXIgd3Jvbmc=",
+	"VG8gc29tZSB3aG8gYXJlIG5lYXIgbXkgaGVhcnQs",
+	"WWV0IEkgbnVtYmVyIGhpbSBpbiB0aGUgc29uZzs=",
+	"SGUsIHRvbywgaGFzIHJlc2lnbmVkIGhpcyBwYXJ0",
+	"SW4gdGhlIGNhc3VhbCBjb21lZHk7",
+	"SGUsIHRvbywgaGFzIGJlZW4gY2hhbmdlZCBpbiBoaXMgdHVybiw=",
+	"VHJhbnNmb3JtZWQgdXR0ZXJseTo=",
+	"QSB0ZXJyaWJsZSBiZWF1dHkgaXMgYm9ybi4=",
+}
+
+// BreakFixedNonceCTR guesses the keystream as far as the longest ciphertext goes. Byte i of every ciphertext
+// is XORed with the same keystream byte, so column i is single-byte XOR, like each transposed block of s1
+// challenge 6, and xorbreak solves it. The columns near the end have only a few bytes each, so expect that
+// part to need substitutions.
+func BreakFixedNonceCTR(cipherTexts [][]byte) []byte {
+	return xorbreak.BestLineKey(cipherTexts)
+}
+
+// SubstitutePlainText fixes the keystream under the guess that cipherText decrypts to plainText at offset,
+// growing the keystream if the guess runs past its end
+func SubstitutePlainText(keyStream, cipherText []byte, offset int, plainText []byte) []byte {
+	if end := offset + len(plainText); end > len(keyStream) {
+		keyStream = append(keyStream, make([]byte, end-len(keyStream))...)
+	}
+	for i := range plainText {
+		keyStream[offset+i] = cipherText[offset+i] ^ plainText[i]
+	}
+	return keyStream
+}
+
+// DecryptWithKeyStream XORs each ciphertext with the keystream, as far as the keystream goes
+func DecryptWithKeyStream(cipherTexts [][]byte, keyStream []byte) [][]byte {
+	plainTexts := make([][]byte, len(cipherTexts))
+	for i, cipherText := range cipherTexts {
+		n := len(cipherText)
+		if n > len(keyStream) {
+			n = len(keyStream)
+		}
+		plainTexts[i] = make([]byte, n)
+		for j := 0; j < n; j++ {
+			plainTexts[i][j] = cipherText[j] ^ keyStream[j]
+		}
+	}
+	return plainTexts
+}
+
+// EncryptFixedNonce encrypts every plaintext separately under one random key and nonce 0
+func EncryptFixedNonce(plainTexts [][]byte) [][]byte {
+	block, err := NewAESECBBlock(GenerateRandomAESKey())
+	if err != nil {
+		log.Fatal(err)
+	}
+	cipherTexts := make([][]byte, len(plainTexts))
+	for i, plainText := range plainTexts {
+		cipherTexts[i] = CTRCrypt(block, make([]byte, 16), CounterLE64, plainText)
+	}
+	return cipherTexts
+}
+
+func decodeFixedNonceLines() [][]byte {
+	plainTexts := make([][]byte, len(fixedNonceLines))
+	for i, line := range fixedNonceLines {
+		plainText, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			log.Fatal(err)
+		}
+		plainTexts[i] = plainText
+	}
+	return plainTexts
+}
+
+func TestBreakFixedNonceCTRWithSubstitutions(t *testing.T) {
+	plainTexts := decodeFixedNonceLines()
+	cipherTexts := EncryptFixedNonce(plainTexts)
+
+	keyStream := BreakFixedNonceCTR(cipherTexts)
+	assert.Equal(t, 38, len(keyStream))
+
+	// the columns every line reaches have 40 bytes to score, and those come out right
+	shortest := len(plainTexts[0])
+	for _, plainText := range plainTexts {
+		if len(plainText) < shortest {
+			shortest = len(plainText)
+		}
+	}
+	decrypted := DecryptWithKeyStream(cipherTexts, keyStream)
+	for i := range plainTexts {
+		assert.Equal(t, plainTexts[i][:shortest], decrypted[i][:shortest])
+	}
+	for _, line := range decrypted {
+		log.Printf("%q", line)
+	}
+
+	// the last columns have a line or two each; knowing the poem, fix them from the longest line
+	longest := 37
+	assert.Equal(t, "He, too, has been changed in his turn,", string(plainTexts[longest]))
+	keyStream = SubstitutePlainText(keyStream, cipherTexts[longest], 0, []byte("He, too, has been changed in his turn,"))
+	assert.Equal(t, plainTexts, DecryptWithKeyStream(cipherTexts, keyStream))
+}
+
+func TestSubstitutePlainTextGrowsKeyStream(t *testing.T) {
+	cipherTexts := EncryptFixedNonce([][]byte{[]byte("A terrible beauty is born."), []byte("All changed")})
+	keyStream := SubstitutePlainText(nil, cipherTexts[1], 0, []byte("All changed"))
+	assert.Equal(t, 11, len(keyStream))
+	keyStream = SubstitutePlainText(keyStream, cipherTexts[0], 11, []byte("beauty is born."))
+	assert.Equal(t, "A terrible beauty is born.", string(DecryptWithKeyStream(cipherTexts, keyStream)[0]))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"s1/xorbreak"
+)
+
+/*
+
+Break fixed-nonce CTR statistically
+
+In this file find a similar set of Base64'd plaintext. Do with them exactly what you did with the first, but
+solve the problem differently.
+
+Instead of making spot guesses at to known plaintext, treat the collection of ciphertexts the same way you
+would repeating-key XOR.
+
+Obviously, CTR encryption appears different from repeated-key XOR, but with a fixed nonce they are
+effectively the same thing.
+
+To exploit this: take your collection of ciphertexts and truncate them to a common length (the length of the
+smallest ciphertext will work).
+
+Solve the resulting concatenation of ciphertexts as if for repeating- key XOR, with a key size of the length
+of the ciphertext you XOR'd.
+
+*/
+
+// TruncateToShortest cuts every ciphertext to the length of the shortest one, skipping empty ones
+func TruncateToShortest(cipherTexts [][]byte) [][]byte {
+	shortest := -1
+	for _, cipherText := range cipherTexts {
+		if len(cipherText) > 0 && (shortest < 0 || len(cipherText) < shortest) {
+			shortest = len(cipherText)
+		}
+	}
+	var truncated [][]byte
+	for _, cipherText := range cipherTexts {
+		if len(cipherText) > 0 {
+			truncated = append(truncated, cipherText[:shortest])
+		}
+	}
+	return truncated
+}
+
+// BreakFixedNonceCTRStatistically solves the truncated ciphertexts as repeating-key XOR with the key size
+// of their common length: every column gets a byte from every ciphertext
+func BreakFixedNonceCTRStatistically(cipherTexts [][]byte) []byte {
+	return xorbreak.BestLineKey(TruncateToShortest(cipherTexts))
+}
+
+func TestTruncateToShortest(t *testing.T) {
+	truncated := TruncateToShortest([][]byte{[]byte("abcd"), {}, []byte("ef"), []byte("ghi")})
+	assert.Equal(t, [][]byte{[]byte("ab"), []byte("ef"), []byte("gh")}, truncated)
+	assert.Nil(t, TruncateToShortest(nil))
+}
+
+// The challenge file is not in this repository; the lines of the ECB exercise plaintext (s1/7.txt) are the same
+// kind of text and serve instead.
+func TestBreakFixedNonceCTRStatistically(t *testing.T) {
+	plainTexts := bytes.Split(ReadECBChallengeText(), []byte("\n"))
+	cipherTexts := EncryptFixedNonce(plainTexts)
+
+	keyStream := BreakFixedNonceCTRStatistically(cipherTexts)
+	expected := TruncateToShortest(plainTexts)
+	assert.Equal(t, len(expected[0]), len(keyStream))
+	assert.Equal(t, expected, DecryptWithKeyStream(TruncateToShortest(cipherTexts), keyStream))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+Break "random access read/write" AES CTR
+
+Back to CTR. Encrypt the recovered plaintext from this file <25.txt> (the ECB exercise) under CTR with a random key
+(for this exercise the key should be unknown to you, but hold on to it).
+
+Now, write the code that allows you to "seek" into the ciphertext, decrypt, and re-encrypt with different
+plaintext. Expose this as a function, like, "edit(ciphertext, key, offset, newtext)".
+
+Imagine the "edit" function was exposed to attackers by means of an API call that didn't reveal the key or
+the original plaintext; the attacker has the ciphertext and controls the offset and "new text".
+
+Recover the original plaintext.
+
+Food for thought.
+
+A folkloric supposed benefit of CTR mode is the ability to easily "seek forward" into the ciphertext; to access
+byte N of the ciphertext, all you need to be able to do is generate byte N of the keystream. Imagine if you'd
+set up a "CTR encryption server" that allowed you to make "edit" requests; you couldn't verify that the edits
+were authorized, so you'd be crazy to let anyone do that.
+
+Make sure to understand why this was insecure.
+
+*/
+
+// ReadECBChallengeText decrypts the challenge 7 file with the ECB key. The challenge 25 file is the same file,
+// so it is read from set 1 instead of being kept twice.
+func ReadECBChallengeText() []byte {
+	block, err := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	plainText, err := DecryptECB(block, ReadBase64File("../s1/7.txt"), PKCS7Padding)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return plainText
+}
+
+// CTRStorage is random-access encrypted storage: the ciphertext is public, edits re-encrypt in place
+type CTRStorage struct {
+	stream     *CTR
+	cipherText []byte
+}
+
+func NewCTRStorage(plainText []byte) *CTRStorage {
+	block, err := NewAESECBBlock(GenerateRandomAESKey())
+	if err != nil {
+		log.Fatal(err)
+	}
+	// random nonce, counter starting at 0
+	IV := append(GenerateRandomKey(8), make([]byte, 8)...)
+	s := &CTRStorage{stream: NewCTR(block, IV, CounterLE64)}
+	s.cipherText = make([]byte, len(plainText))
+	s.stream.XORKeyStream(s.cipherText, plainText)
+	return s
+}
+
+func (s *CTRStorage) CipherText() []byte {
+	return append([]byte{}, s.cipherText...)
+}
+
+// Edit replaces the plaintext at offset with newText, growing the storage if newText runs past the end,
+// and returns the new ciphertext
+func (s *CTRStorage) Edit(offset int, newText []byte) ([]byte, error) {
+	if offset < 0 || offset > len(s.cipherText) {
+		return nil, errors.New("Edit offset out of range")
+	}
+	if end := offset + len(newText); end > len(s.cipherText) {
+		s.cipherText = append(s.cipherText, make([]byte, end-len(s.cipherText))...)
+	}
+	s.stream.Seek(uint64(offset))
+	s.stream.XORKeyStream(s.cipherText[offset:offset+len(newText)], newText)
+	return s.CipherText(), nil
+}
+
+// RecoverPlaintextViaEdit overwrites everything with zeros. Editing writes newtext XOR keystream where the old
+// ciphertext was, so the resulting ciphertext is the keystream itself.
+func RecoverPlaintextViaEdit(cipherText []byte, edit func(offset int, newText []byte) ([]byte, error)) ([]byte, error) {
+	keyStream, err := edit(0, make([]byte, len(cipherText)))
+	if err != nil {
+		return nil, err
+	}
+	plainText := make([]byte, len(cipherText))
+	for i := range cipherText {
+		plainText[i] = cipherText[i] ^ keyStream[i]
+	}
+	return plainText, nil
+}
+
+func TestCTREdit(t *testing.T) {
+	storage := NewCTRStorage([]byte("Ice, Ice, baby, too cold, too cold"))
+	before := storage.CipherText()
+
+	after, err := storage.Edit(5, []byte("ICE"))
+	assert.Nil(t, err)
+	assert.Equal(t, before[:5], after[:5])
+	assert.Equal(t, before[8:], after[8:])
+	assert.NotEqual(t, before[5:8], after[5:8])
+
+	// editing the original text back restores the original ciphertext
+	after, err = storage.Edit(5, []byte("Ice"))
+	assert.Nil(t, err)
+	assert.Equal(t, before, after)
+
+	// appending past the end
+	after, err = storage.Edit(len(before), []byte(" Vanilla"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(before)+8, len(after))
+
+	_, err = storage.Edit(len(after)+1, []byte("x"))
+	assert.NotNil(t, err)
+}
+
+func TestRecoverPlaintextViaEdit(t *testing.T) {
+	expected := ReadECBChallengeText()
+	assert.True(t, strings.HasPrefix(string(expected), "I'm back and I'm ringin' the bell"))
+
+	storage := NewCTRStorage(expected)
+	cipherText := storage.CipherText()
+
+	plainText, err := RecoverPlaintextViaEdit(cipherText, storage.Edit)
+	assert.Nil(t, err)
+	assert.True(t, bytes.Equal(expected, plainText))
+}
+
+func TestRecoverPlaintextByEditingCipherTextOverItself(t *testing.T) {
+	expected := []byte("Play that funky music, white boy")
+	storage := NewCTRStorage(expected)
+
+	plainText, err := storage.Edit(0, storage.CipherText())
+	assert.Nil(t, err)
+	assert.Equal(t, expected, plainText)
+}
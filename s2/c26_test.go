@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+CTR bitflipping
+
+There are people in the world that believe that CTR resists bit flipping attacks of the kind to which CBC mode
+is susceptible.
+
+Re-implement the CBC bitflipping exercise from earlier to use CTR mode instead of CBC mode.
+Inject an "admin=true" token.
+
+*/
+
+// CTRFlip returns a copy of cipherText in which known, the plaintext at offset, decrypts to desired instead.
+// Plaintext byte i is ciphertext byte i XOR keystream byte i, so flipping a ciphertext bit flips the same
+// plaintext bit and nothing else: unlike CBCBitflip, no block gets garbled and any byte can be edited.
+func CTRFlip(cipherText []byte, offset int, known, desired []byte) ([]byte, error) {
+	if len(known) != len(desired) {
+		return nil, errors.New("Known and desired plaintext must have the same length")
+	}
+	if offset < 0 || offset+len(known) > len(cipherText) {
+		return nil, errors.New("Edit out of range")
+	}
+	flipped := append([]byte{}, cipherText...)
+	for i := range known {
+		flipped[offset+i] ^= known[i] ^ desired[i]
+	}
+	return flipped, nil
+}
+
+// CTRCookieServer is the c13 profile service and the c16 comment service, encrypting with CTR under a random nonce
+type CTRCookieServer struct {
+	key []byte
+}
+
+func NewCTRCookieServer() *CTRCookieServer {
+	return &CTRCookieServer{key: GenerateRandomAESKey()}
+}
+
+// encrypt puts the 8-byte nonce in front of the ciphertext
+func (s *CTRCookieServer) encrypt(plainText string) []byte {
+	block, err := NewAESECBBlock(s.key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	nonce := GenerateRandomKey(8)
+	IV := append(append([]byte{}, nonce...), make([]byte, 8)...)
+	return append(nonce, CTRCrypt(block, IV, CounterLE64, []byte(plainText))...)
+}
+
+func (s *CTRCookieServer) decrypt(cookie []byte) (string, error) {
+	if len(cookie) < 8 {
+		return "", errors.New("Cookie too short")
+	}
+	block, err := NewAESECBBlock(s.key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	IV := append(append([]byte{}, cookie[:8]...), make([]byte, 8)...)
+	return string(CTRCrypt(block, IV, CounterLE64, cookie[8:])), nil
+}
+
+func (s *CTRCookieServer) ProfileFor(email string) []byte {
+	return s.encrypt(GenerateProfileFor(email))
+}
+
+func (s *CTRCookieServer) Profile(cookie []byte) (map[string]string, error) {
+	plainText, err := s.decrypt(cookie)
+	if err != nil {
+		return nil, err
+	}
+	// the plaintext is whatever the attacker flipped it into, so a malformed one is an error, not a crash
+	parsed, err := url.ParseQuery(plainText)
+	if err != nil {
+		return nil, err
+	}
+	profile := make(map[string]string)
+	for k, v := range parsed {
+		profile[k] = v[0]
+	}
+	return profile, nil
+}
+
+func (s *CTRCookieServer) Comment(userData string) []byte {
+	return s.encrypt(CommentCookie(userData))
+}
+
+func (s *CTRCookieServer) IsAdmin(cookie []byte) (bool, error) {
+	plainText, err := s.decrypt(cookie)
+	if err != nil {
+		return false, err
+	}
+	return IsAdminCookie(plainText), nil
+}
+
+// InjectRoleAdmin registers an email ending in "XroleXadmin" and flips the two X into '&' and '='.
+// The injected role comes first, so it wins over role=user at the end.
+func InjectRoleAdmin(profileFor func(string) []byte) ([]byte, error) {
+	email := "foo@bar.com"
+	known, desired := "XroleXadmin", "&role=admin"
+	cookie := profileFor(email + known)
+	// 8 bytes of nonce, then "email=" and the address
+	return CTRFlip(cookie, 8+len("email=")+len(email), []byte(known), []byte(desired))
+}
+
+// InjectAdminTrue is the challenge: the look-alike ":admin<true:" right after the comment prefix becomes ";admin=true;"
+func InjectAdminTrue(comment func(string) []byte) ([]byte, error) {
+	known, desired := ":admin<true:", ";admin=true;"
+	cookie := comment(known)
+	return CTRFlip(cookie, 8+commentUserDataOffset(), []byte(known), []byte(desired))
+}
+
+func TestCTRCookiesQuoteInput(t *testing.T) {
+	server := NewCTRCookieServer()
+	profile, err := server.Profile(server.ProfileFor("foo@bar.com&role=admin"))
+	assert.Nil(t, err)
+	assert.Equal(t, "user", profile["role"])
+
+	admin, err := server.IsAdmin(server.Comment(";admin=true;"))
+	assert.Nil(t, err)
+	assert.False(t, admin)
+}
+
+func TestCTRProfileRejectsMalformedCookie(t *testing.T) {
+	server := NewCTRCookieServer()
+	cookie := server.ProfileFor("foo@bar.com")
+	// the cookie starts with an 8-byte nonce, then "email=..."; turn the 'e' into a ';'
+	cookie[8] ^= 'e' ^ ';'
+	_, err := server.Profile(cookie)
+	assert.NotNil(t, err)
+}
+
+func TestCTRBitflipRoleAdmin(t *testing.T) {
+	server := NewCTRCookieServer()
+	cookie, err := InjectRoleAdmin(server.ProfileFor)
+	assert.Nil(t, err)
+
+	profile, err := server.Profile(cookie)
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", profile["role"])
+	assert.Equal(t, "foo@bar.com", profile["email"])
+}
+
+func TestCTRBitflipAdminTrue(t *testing.T) {
+	server := NewCTRCookieServer()
+	cookie, err := InjectAdminTrue(server.Comment)
+	assert.Nil(t, err)
+
+	admin, err := server.IsAdmin(cookie)
+	assert.Nil(t, err)
+	assert.True(t, admin)
+
+	// unlike CBC, nothing around the edit is garbled
+	plainText, _ := server.decrypt(cookie)
+	assert.Equal(t, strings.Replace(CommentCookie(":admin<true:"), ":admin<true:", ";admin=true;", 1), plainText)
+}
+
+func TestCTRFlipRange(t *testing.T) {
+	_, err := CTRFlip(make([]byte, 4), 2, []byte("abc"), []byte("xyz"))
+	assert.NotNil(t, err)
+	_, err = CTRFlip(make([]byte, 4), 0, []byte("ab"), []byte("xyz"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+Recover the key from CBC with IV=Key
+
+Take your code from the CBC exercise and modify it so that it repurposes the key for CBC encryption as the IV.
+
+Applications sometimes use the key as an IV on the auspices that both the sender and the receiver have to know
+the key already, and can save some space by using it as both a key and an IV.
+
+Using the key as an IV is insecure; an attacker that can modify ciphertext in flight can get the receiver
+to decrypt a value that will reveal the key.
+
+The CBC code from exercise 16 encrypts a URL string. Verify each byte of the plaintext for ASCII compliance
+(ie, look for high-ASCII values). Noncompliant messages should raise an exception or return an error that
+includes the decrypted plaintext (this happens all the time in real systems, for what it's worth).
+
+Use your code to encrypt a message that is at least 3 blocks long:
+
+AES-CBC(P_1, P_2, P_3) -> C_1, C_2, C_3
+
+Modify the message (you are now the attacker):
+
+C_1, C_2, C_3 -> C_1, 0, C_1
+
+Decrypt the message (you are now the receiver) and raise the appropriate error if high-ASCII is found.
+
+As the attacker, recovering the plaintext from the error, extract the key:
+
+P'_1 XOR P'_3
+
+*/
+
+// NonASCIIError is the receiver complaining about high-ASCII plaintext, and helpfully including it
+type NonASCIIError struct {
+	PlainText []byte
+}
+
+func (e *NonASCIIError) Error() string {
+	return fmt.Sprintf("Invalid characters in message: %q", e.PlainText)
+}
+
+// IVKeyServer encrypts comment cookies with AES-CBC, using the key as the IV
+type IVKeyServer struct {
+	key []byte
+}
+
+func NewIVKeyServer() *IVKeyServer {
+	return &IVKeyServer{key: GenerateRandomAESKey()}
+}
+
+func (s *IVKeyServer) Encrypt(userData string) []byte {
+	return EncryptCBCviaECB([]byte(CommentCookie(userData)), s.key, s.key)
+}
+
+// Decrypt returns a NonASCIIError holding the plaintext if any byte is high-ASCII
+func (s *IVKeyServer) Decrypt(cipherText []byte) ([]byte, error) {
+	plainText, err := DecryptCBCviaECB(cipherText, s.key, s.key)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range plainText {
+		if b >= 0x80 {
+			return nil, &NonASCIIError{PlainText: plainText}
+		}
+	}
+	return plainText, nil
+}
+
+func (s *IVKeyServer) IsAdmin(cipherText []byte) (bool, error) {
+	plainText, err := s.Decrypt(cipherText)
+	if err != nil {
+		return false, err
+	}
+	return IsAdminCookie(string(plainText)), nil
+}
+
+// RecoverKeyFromIVKey sends C_1, 0, C_1 and reads the key out of the error: P'_1 = D(C_1) xor IV = D(C_1) xor K,
+// and P'_3 = D(C_1) xor 0. The receiver checks the padding before it looks at the characters, so the last two
+// original blocks go after the modified ones: their padding still decrypts fine.
+func RecoverKeyFromIVKey(encrypt func(string) []byte, decrypt func([]byte) ([]byte, error), blockSize int) ([]byte, error) {
+	cipherText := encrypt("")
+	if len(cipherText) < 5*blockSize {
+		return nil, errors.New("Need a ciphertext of at least five blocks")
+	}
+
+	modified := append([]byte{}, cipherText[:blockSize]...)
+	modified = append(modified, make([]byte, blockSize)...)
+	modified = append(modified, cipherText[:blockSize]...)
+	modified = append(modified, cipherText[len(cipherText)-2*blockSize:]...)
+
+	_, err := decrypt(modified)
+	var nonASCII *NonASCIIError
+	if !errors.As(err, &nonASCII) {
+		return nil, fmt.Errorf("Receiver did not leak the plaintext: %v", err)
+	}
+
+	key := make([]byte, blockSize)
+	for i := range key {
+		key[i] = nonASCII.PlainText[i] ^ nonASCII.PlainText[2*blockSize+i]
+	}
+	return key, nil
+}
+
+func TestRecoverKeyFromIVKey(t *testing.T) {
+	server := NewIVKeyServer()
+
+	plainText, err := server.Decrypt(server.Encrypt("just a normal user"))
+	assert.Nil(t, err)
+	assert.Equal(t, CommentCookie("just a normal user"), string(plainText))
+
+	key, err := RecoverKeyFromIVKey(server.Encrypt, server.Decrypt, 16)
+	assert.Nil(t, err)
+	assert.Equal(t, server.key, key)
+
+	// with the key, the attacker is admin
+	forged := EncryptCBCviaECB([]byte("comment1=x;admin=true;comment2=y"), key, key)
+	admin, err := server.IsAdmin(forged)
+	assert.Nil(t, err)
+	assert.True(t, admin)
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"crypto/cipher"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"s2/blockmode"
+	"s2/cbcmac"
+)
+
+/*
+
+CBC-MAC Message Forgery
+
+Let's talk about CBC-MAC.
+
+CBC-MAC is like this:
+
+    Take the plaintext P.
+    Encrypt P under CBC with key K, yielding ciphertext C.
+    Chuck all of C but the last block C[n].
+    C[n] is the MAC.
+
+Suppose there's an online banking application, and it carries out user requests by talking to an API server
+over the network. Each request looks like this:
+
+message || IV || MAC
+
+The message looks like this:
+
+from=#{from_id}&to=#{to_id}&amount=#{amount}
+
+Now, write an API server and a web frontend for it. (NOTE: No need to get ambitious and write actual servers
+and web apps. Totally fine to go lo-fi on this one.) The client and server should share a secret key K to sign
+and verify messages.
+
+The API server should accept messages, verify signatures, and carry out each transaction if the MAC is valid.
+It's also publicly exposed - the attacker can submit messages freely assuming they can forge the right MAC.
+
+The web client should allow the attacker to generate valid messages for accounts they control.
+(Feel free to sanity check params if you're feeling anal-retentive.) Assume the attacker is in a position
+to capture and inspect messages from the client to the API server.
+
+One thing we haven't discussed is the IV. Assume the client generates a per-message IV and sends it along
+with the MAC. That's how CBC works, right?
+
+Wrong.
+
+For messages signed under CBC-MAC, an attacker-controlled IV is a liability. Why? Because you can modify
+the first block of the message.
+
+This means that the first block of the message is totally under the attacker's control. Use this fact
+to generate a message transferring 1M spacebucks from a target victim's account into your account.
+
+Now let's tune up that protocol a little bit.
+
+As we now know, you're supposed to use a fixed IV with CBC-MAC, so let's do that. We'll set ours at 0
+for simplicity. This means the IV comes out of the protocol:
+
+message || MAC
+
+Pretty simple, but we'll also adjust the message. For the purposes of efficiency, the bank wants to be able
+to process multiple transactions in a single request. So the message now looks like this:
+
+from=#{from_id}&tx_list=#{transactions}
+
+With the transaction list formatted like:
+
+to:amount(;to:amount)*
+
+There's still a weakness here: the MAC is vulnerable to length extension attacks. How?
+
+Well, the output of CBC-MAC is a valid IV for a new message.
+
+"But we don't control the IV anymore!"
+
+With sufficient mastery of CBC, we can fake it.
+
+Your mission: capture a valid message from your target user. Use length extension to add a transaction
+paying the attacker's account 1M spacebucks.
+
+Hint: This would be a lot easier if you had full control over the first block of your message, huh?
+Maybe you can simulate that.
+
+Food for thought: How would you modify the protocol to prevent this?
+
+(One answer: CMAC, below. Its last block goes through a key-dependent tweak, so a MAC is no longer the chaining value
+a longer message passes through.)
+
+*/
+
+// CBCMAC is the last block of the PKCS#7 padded CBC encryption of message
+func CBCMAC(block cipher.Block, message, IV []byte) []byte {
+	return cbcmac.MAC(block, message, IV)
+}
+
+// doubleBlock multiplies by x in GF(2^n), the subkey step of CMAC
+func doubleBlock(in []byte) []byte {
+	out := make([]byte, len(in))
+	for i := 0; i < len(in)-1; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[len(in)-1] = in[len(in)-1] << 1
+	if in[0]&0x80 != 0 {
+		// x^128 = x^7 + x^2 + x + 1, x^64 = x^4 + x^3 + x + 1
+		if len(in) == 16 {
+			out[len(in)-1] ^= 0x87
+		} else {
+			out[len(in)-1] ^= 0x1b
+		}
+	}
+	return out
+}
+
+// cmacSubkeys derives K1 and K2 from L = E(K, 0)
+func cmacSubkeys(block cipher.Block) ([]byte, []byte) {
+	L := make([]byte, block.BlockSize())
+	block.Encrypt(L, L)
+	K1 := doubleBlock(L)
+	return K1, doubleBlock(K1)
+}
+
+// CMAC is RFC 4493 AES-CMAC, or OMAC1 for 64-bit block ciphers
+func CMAC(block cipher.Block, message []byte) []byte {
+	blockSize := block.BlockSize()
+	if blockSize != 8 && blockSize != 16 {
+		panic("CMAC: only 64 and 128-bit block ciphers are supported")
+	}
+	K1, K2 := cmacSubkeys(block)
+
+	// a complete last block is tweaked with K1, a padded (10*) one with K2
+	n := (len(message) + blockSize - 1) / blockSize
+	last := make([]byte, blockSize)
+	subkey := K1
+	if n == 0 || len(message)%blockSize != 0 {
+		if n == 0 {
+			n = 1
+		}
+		copy(last, message[(n-1)*blockSize:])
+		last[len(message)-(n-1)*blockSize] = 0x80
+		subkey = K2
+	} else {
+		copy(last, message[(n-1)*blockSize:])
+	}
+	for i := range last {
+		last[i] ^= subkey[i]
+	}
+
+	head := append(append([]byte{}, message[:(n-1)*blockSize]...), last...)
+	return cbcmac.State(block, head, make([]byte, blockSize))
+}
+
+type Transaction struct {
+	To, Amount int
+}
+
+// Bank is the API server: it shares its key with the web client and moves money for every request with a valid MAC
+type Bank struct {
+	block    cipher.Block
+	Balances map[int]int
+}
+
+func NewBank() *Bank {
+	block, err := NewAESECBBlock(GenerateRandomAESKey())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &Bank{block: block, Balances: make(map[int]int)}
+}
+
+// parseBankMessage splits k=v pairs on & and the first =; unlike url.ParseQuery it does not mind
+// ';' (the transaction separator) or the binary junk a forged message may contain
+func parseBankMessage(message string) map[string]string {
+	fields := make(map[string]string)
+	for _, pair := range strings.Split(message, "&") {
+		if k, v, ok := strings.Cut(pair, "="); ok {
+			if _, seen := fields[k]; !seen {
+				fields[k] = v
+			}
+		}
+	}
+	return fields
+}
+
+// ParseTransactions reads to:amount(;to:amount)* and skips entries that do not parse, as a forgiving server would
+func ParseTransactions(list string) []Transaction {
+	transactions := make([]Transaction, 0)
+	for _, entry := range strings.Split(list, ";") {
+		to, amount, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+		toID, err1 := strconv.Atoi(to)
+		value, err2 := strconv.Atoi(amount)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		transactions = append(transactions, Transaction{To: toID, Amount: value})
+	}
+	return transactions
+}
+
+func (b *Bank) transfer(from int, transactions []Transaction) {
+	for _, tx := range transactions {
+		b.Balances[from] -= tx.Amount
+		b.Balances[tx.To] += tx.Amount
+	}
+}
+
+// ProcessV1 handles message || IV || MAC
+func (b *Bank) ProcessV1(request []byte) error {
+	blockSize := b.block.BlockSize()
+	if len(request) < 2*blockSize {
+		return errors.New("Request too short")
+	}
+	message := request[:len(request)-2*blockSize]
+	IV := request[len(message) : len(message)+blockSize]
+	if !hmac.Equal(CBCMAC(b.block, message, IV), request[len(request)-blockSize:]) {
+		return errors.New("Invalid MAC")
+	}
+
+	fields := parseBankMessage(string(message))
+	from, err := strconv.Atoi(fields["from"])
+	if err != nil {
+		return fmt.Errorf("Invalid sender: %w", err)
+	}
+	to, err := strconv.Atoi(fields["to"])
+	if err != nil {
+		return fmt.Errorf("Invalid recipient: %w", err)
+	}
+	amount, err := strconv.Atoi(fields["amount"])
+	if err != nil {
+		return fmt.Errorf("Invalid amount: %w", err)
+	}
+	b.transfer(from, []Transaction{{To: to, Amount: amount}})
+	return nil
+}
+
+// ProcessV2 handles message || MAC with a zero IV and a transaction list
+func (b *Bank) ProcessV2(request []byte) error {
+	blockSize := b.block.BlockSize()
+	if len(request) < blockSize {
+		return errors.New("Request too short")
+	}
+	message := request[:len(request)-blockSize]
+	if !hmac.Equal(CBCMAC(b.block, message, make([]byte, blockSize)), request[len(message):]) {
+		return errors.New("Invalid MAC")
+	}
+
+	fields := parseBankMessage(string(message))
+	from, err := strconv.Atoi(fields["from"])
+	if err != nil {
+		return fmt.Errorf("Invalid sender: %w", err)
+	}
+	b.transfer(from, ParseTransactions(fields["tx_list"]))
+	return nil
+}
+
+// BankClient is the web frontend of one logged in user; it only signs transfers out of that user's account
+type BankClient struct {
+	block cipher.Block
+	user  int
+}
+
+func (b *Bank) Client(user int) *BankClient {
+	return &BankClient{block: b.block, user: user}
+}
+
+func (c *BankClient) SignTransferV1(to, amount int) []byte {
+	message := []byte(fmt.Sprintf("from=%d&to=%d&amount=%d", c.user, to, amount))
+	IV := GenerateRandomKey(c.block.BlockSize())
+	request := append(message, IV...)
+	return append(request, CBCMAC(c.block, message, IV)...)
+}
+
+func (c *BankClient) SignTransferV2(transactions []Transaction) []byte {
+	entries := make([]string, len(transactions))
+	for i, tx := range transactions {
+		entries[i] = fmt.Sprintf("%d:%d", tx.To, tx.Amount)
+	}
+	message := []byte(fmt.Sprintf("from=%d&tx_list=%s", c.user, strings.Join(entries, ";")))
+	return append(message, CBCMAC(c.block, message, make([]byte, c.block.BlockSize()))...)
+}
+
+// ForgeCBCMACFirstBlock returns the IV under which forged has the same CBC-MAC as message under IV.
+// The two messages may only differ in their first block.
+func ForgeCBCMACFirstBlock(message, IV, forged []byte) ([]byte, error) {
+	blockSize := len(IV)
+	if len(forged) != len(message) || len(message) < blockSize || string(forged[blockSize:]) != string(message[blockSize:]) {
+		return nil, errors.New("Only the first block can be changed")
+	}
+	// IV' xor P1' = IV xor P1, so the first block cipher call sees the same input
+	forgedIV := make([]byte, blockSize)
+	for i := range forgedIV {
+		forgedIV[i] = IV[i] ^ message[i] ^ forged[i]
+	}
+	return forgedIV, nil
+}
+
+// ExtendCBCMAC glues extension onto message, given their zero-IV CBC-MACs. The result has the MAC of extension:
+// the first block of extension is XORed with the MAC of message, which cancels the chaining value
+// the padded message leaves behind. The first block of extension turns into junk in the process.
+func ExtendCBCMAC(message, messageMAC, extension []byte) []byte {
+	blockSize := len(messageMAC)
+	forged := PKCS7Padding.Pad(append([]byte{}, message...), blockSize)
+	glue := make([]byte, blockSize)
+	for i := range glue {
+		glue[i] = extension[i] ^ messageMAC[i]
+	}
+	forged = append(forged, glue...)
+	return append(forged, extension[blockSize:]...)
+}
+
+func TestCMACRFC4493(t *testing.T) {
+	block, _ := NewAESECBBlock(mustDecodeHex(blockmode.SP80038AKey))
+	K1, K2 := cmacSubkeys(block)
+	assert.Equal(t, "fbeed618357133667c85e08f7236a8de", hex.EncodeToString(K1))
+	assert.Equal(t, "f7ddac306ae266ccf90bc11ee46d513b", hex.EncodeToString(K2))
+
+	message := mustDecodeHex(blockmode.SP80038APlainText)
+	vectors := []struct {
+		length int
+		mac    string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+	for _, v := range vectors {
+		assert.Equal(t, v.mac, hex.EncodeToString(CMAC(block, message[:v.length])), "Mlen = %d", v.length)
+	}
+}
+
+func TestCBCMAC(t *testing.T) {
+	block, _ := NewDESCipher([]byte("SUBMARIN"))
+	IV := make([]byte, 8)
+	message := []byte("from=1&to=2&amount=3")
+	cipherText := EncryptCBC(block, message, IV, PKCS7Padding)
+	assert.Equal(t, cipherText[len(cipherText)-8:], CBCMAC(block, message, IV))
+	assert.Equal(t, 8, len(CMAC(block, message)))
+}
+
+func TestCBCMACForgeryWithAttackerIV(t *testing.T) {
+	bank := NewBank()
+	victim, attacker := 1, 2
+	bank.Balances[victim] = 5000000
+
+	// a legitimate request out of the attacker's own account
+	request := bank.Client(attacker).SignTransferV1(attacker, 1000000)
+	message := request[:len(request)-32]
+	IV, mac := request[len(request)-32:len(request)-16], request[len(request)-16:]
+	assert.Equal(t, "from=2&to=2&amount=1000000", string(message))
+
+	forgedMessage := []byte(strings.Replace(string(message), "from=2", "from=1", 1))
+	forgedIV, err := ForgeCBCMACFirstBlock(message, IV, forgedMessage)
+	assert.Nil(t, err)
+	forged := append(append(forgedMessage, forgedIV...), mac...)
+
+	assert.Nil(t, bank.ProcessV1(forged))
+	assert.Equal(t, 4000000, bank.Balances[victim])
+	assert.Equal(t, 1000000, bank.Balances[attacker])
+
+	// a change past the first block cannot be absorbed by the IV
+	_, err = ForgeCBCMACFirstBlock(message, IV, []byte("from=2&to=2&amount=9000000"))
+	assert.NotNil(t, err)
+	tampered := append([]byte("from=2&to=2&amount=9000000"), request[len(message):]...)
+	assert.NotNil(t, bank.ProcessV1(tampered))
+}
+
+func TestCBCMACLengthExtension(t *testing.T) {
+	bank := NewBank()
+	victim, attacker := 1, 2
+
+	// the attacker signs a message of their own whose first block becomes the glue; the rest is the payload.
+	// The glue is the attacker's first block XORed with the victim's MAC, junk that could contain separators,
+	// so the attacker waits for another captured transfer (a new MAC) until the forged message parses their way.
+	own := bank.Client(attacker).SignTransferV2([]Transaction{{To: 0, Amount: 1}, {To: attacker, Amount: 1000000}})
+	ownMessage, ownMAC := own[:len(own)-16], own[len(own)-16:]
+	assert.Equal(t, "from=2&tx_list=0", string(ownMessage[:16]))
+
+	var forged []byte
+	replayed := 0
+	for amount := 100; amount < 200 && forged == nil; amount++ {
+		// captured on the wire: the victim paying two friends
+		captured := bank.Client(victim).SignTransferV2([]Transaction{{To: 3, Amount: amount}, {To: 4, Amount: 50}})
+		message, mac := captured[:len(captured)-16], captured[len(captured)-16:]
+		assert.Nil(t, bank.ProcessV2(captured))
+
+		candidate := ExtendCBCMAC(message, mac, ownMessage)
+		fields := parseBankMessage(string(candidate))
+		// 3:amount is replayed along the way, 4:50 runs into the padding and junk and is skipped
+		expected := []Transaction{{To: 3, Amount: amount}, {To: attacker, Amount: 1000000}}
+		if fields["from"] == "1" && assert.ObjectsAreEqual(expected, ParseTransactions(fields["tx_list"])) {
+			forged, replayed = append(candidate, ownMAC...), amount
+		}
+	}
+	assert.NotNil(t, forged)
+	log.Printf("Forged request: %q", forged)
+
+	before := bank.Balances[victim]
+	assert.Nil(t, bank.ProcessV2(forged))
+	assert.Equal(t, before-replayed-1000000, bank.Balances[victim])
+	assert.Equal(t, 1000000, bank.Balances[attacker])
+}
+
+func TestCMACResistsLengthExtension(t *testing.T) {
+	block, _ := NewAESECBBlock(GenerateRandomAESKey())
+	message := []byte("from=1&tx_list=3:100;4:50")
+	extension := []byte("from=2&tx_list=0:1;2:1000000")
+
+	forged := ExtendCBCMAC(message, CMAC(block, message), extension)
+	assert.NotEqual(t, CMAC(block, extension), CMAC(block, forged))
+}
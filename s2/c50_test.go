@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"s2/cbcmac"
+)
+
+/*
+
+Hashing with CBC-MAC
+
+Sometimes people try to use CBC-MAC as a hash function.
+
+This is a bad idea. Matt Green explains:
+
+    To make a long story short: cryptographic hash functions are public functions (i.e., no secret key)
+    that have the property of collision-resistance (it's hard to find two messages with the same hash).
+    MACs are keyed functions that (typically) provide message unforgeability -- a very different property.
+    Moreover, they guarantee this only when the key is secret.
+
+Let's try a simple exercise.
+
+Hash functions are often used for code verification. This snippet of JavaScript (with newline):
+
+alert('MZA who was that?');
+
+Hashes to 296b8d7cb78a243dda4d0a61d33bbdd1 under CBC-MAC with a key of "YELLOW SUBMARINE" and a 0 IV.
+
+Forge a valid snippet of JavaScript that alerts "Ayo, the Wu is back!" and hashes to the same value.
+Ensure that it runs in a browser.
+
+Extra Credit
+
+Write JavaScript code that downloads your file, checks its CBC-MAC, and inserts it into the DOM iff it matches
+the expected hash.
+
+*/
+
+const (
+	OriginalSnippet = "alert('MZA who was that?');\n"
+	OriginalHash    = "296b8d7cb78a243dda4d0a61d33bbdd1"
+)
+
+func TestOriginalSnippetHash(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	assert.Equal(t, OriginalHash, hex.EncodeToString(CBCMAC(block, []byte(OriginalSnippet), make([]byte, 16))))
+}
+
+func TestForgeSnippet(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	payload := "alert('Ayo, the Wu is back!');"
+
+	forged := cbcmac.ForgeSnippet(block, OriginalSnippet, payload)
+	log.Printf("Forged snippet: %q", forged)
+	assert.Equal(t, OriginalHash, hex.EncodeToString(CBCMAC(block, forged, make([]byte, 16))))
+
+	// the payload runs, and everything after it up to the final newline is one comment line
+	assert.True(t, bytes.HasPrefix(forged, []byte(payload)))
+	comment := forged[bytes.Index(forged, []byte("//")):]
+	assert.Equal(t, 1, bytes.Count(comment, []byte("\n")))
+	assert.True(t, bytes.HasSuffix(comment, []byte("\n")))
+	assert.False(t, bytes.ContainsAny(comment, "\r"))
+}
+
+func TestForgeCBCMACCollisionAnyPrefix(t *testing.T) {
+	block, _ := NewDESCipher([]byte("SUBMARIN"))
+	original := []byte("Rollin' in my 5.0")
+	prefix := GenerateRandomKey(24)
+	forged := cbcmac.ForgeCollision(block, original, prefix)
+	assert.Equal(t, CBCMAC(block, original, make([]byte, 8)), CBCMAC(block, forged, make([]byte, 8)))
+	assert.Panics(t, func() { cbcmac.ForgeCollision(block, original, prefix[:5]) })
+}
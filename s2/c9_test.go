@@ -18,11 +18,21 @@ For instance,
 */
 
 import (
-	"github.com/stretchr/testify/assert"
+	"crypto/subtle"
+	"errors"
+	"fmt"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
+var ErrInvalidPadding = errors.New("invalid padding")
+
+// PadPKCS7 pads cipherText to exactly size bytes; size must leave room for 1 to 255 bytes of padding
 func PadPKCS7(cipherText []byte, size int) []byte {
+	if size <= len(cipherText) || size-len(cipherText) > 255 {
+		panic(fmt.Sprintf("PadPKCS7: cannot pad %d bytes to %d", len(cipherText), size))
+	}
 	padding := byte(size - len(cipherText))
 	newSlice := make([]byte, padding)
 	for i := range newSlice {
@@ -32,6 +42,97 @@ func PadPKCS7(cipherText []byte, size int) []byte {
 	return cipherText
 }
 
+// PadPKCS7Block pads up to the next multiple of blockSize, adding a full block when the input is already aligned
+func PadPKCS7Block(plainText []byte, blockSize int) []byte {
+	return PadPKCS7(plainText, (len(plainText)/blockSize+1)*blockSize)
+}
+
+// UnpadPKCS7 validates every padding byte and strips the padding
+func UnpadPKCS7(plainText []byte, blockSize int) ([]byte, error) {
+	if blockSize <= 0 || blockSize > 255 || len(plainText) == 0 || len(plainText)%blockSize != 0 {
+		return nil, ErrInvalidPadding
+	}
+	padding := plainText[len(plainText)-1]
+	if padding == 0 || int(padding) > blockSize {
+		return nil, ErrInvalidPadding
+	}
+	for _, b := range plainText[len(plainText)-int(padding):] {
+		if b != padding {
+			return nil, ErrInvalidPadding
+		}
+	}
+	return plainText[:len(plainText)-int(padding)], nil
+}
+
+// UnpadPKCS7ConstantTime does the same checks without branching on the padding bytes:
+// it always inspects the whole last block, so timing does not tell a server's clients where the padding went wrong.
+func UnpadPKCS7ConstantTime(plainText []byte, blockSize int) ([]byte, error) {
+	// lengths are public, so these checks do not need to be constant time
+	if blockSize <= 0 || blockSize > 255 || len(plainText) == 0 || len(plainText)%blockSize != 0 {
+		return nil, ErrInvalidPadding
+	}
+	padding := int(plainText[len(plainText)-1])
+	good := subtle.ConstantTimeLessOrEq(1, padding) & subtle.ConstantTimeLessOrEq(padding, blockSize)
+	for i := 1; i <= blockSize; i++ {
+		inPadding := subtle.ConstantTimeLessOrEq(i, padding)
+		matches := subtle.ConstantTimeByteEq(plainText[len(plainText)-i], byte(padding))
+		good &= subtle.ConstantTimeSelect(inPadding, matches, 1)
+	}
+	if good != 1 {
+		return nil, ErrInvalidPadding
+	}
+	return plainText[:len(plainText)-padding], nil
+}
+
 func TestHelloWorld(t *testing.T) {
 	assert.Equal(t, []byte("YELLOW SUBMARINE\x04\x04\x04\x04"), PadPKCS7([]byte("YELLOW SUBMARINE"), 20))
 }
+
+func TestPadPKCS7RejectsShortSize(t *testing.T) {
+	assert.Panics(t, func() { PadPKCS7([]byte("YELLOW SUBMARINE"), 10) })
+	assert.Panics(t, func() { PadPKCS7([]byte("YELLOW SUBMARINE"), 16) })
+}
+
+func TestPadPKCS7Block(t *testing.T) {
+	assert.Equal(t, []byte("YELLOW\x02\x02"), PadPKCS7Block([]byte("YELLOW"), 8))
+	assert.Equal(t, []byte("YELLOW SUBMARINE"+"\x08\x08\x08\x08\x08\x08\x08\x08"), PadPKCS7Block([]byte("YELLOW SUBMARINE"), 8))
+	assert.Equal(t, 32, len(PadPKCS7Block(make([]byte, 16), 16)))
+}
+
+func TestUnpadPKCS7(t *testing.T) {
+	unpadders := map[string]func([]byte, int) ([]byte, error){
+		"strict":        UnpadPKCS7,
+		"constant time": UnpadPKCS7ConstantTime,
+	}
+	for name, unpad := range unpadders {
+		for _, size := range []int{0, 1, 7, 8, 15, 16, 17} {
+			plainText := []byte("ICE ICE BABY ICE ICE BABY")[:size]
+			for _, blockSize := range []int{8, 16} {
+				unpadded, err := unpad(PadPKCS7Block(plainText, blockSize), blockSize)
+				assert.Nil(t, err, name)
+				assert.Equal(t, plainText, unpadded, name)
+			}
+		}
+
+		unpadded, err := unpad([]byte("ICE ICE BABY\x04\x04\x04\x04"), 16)
+		assert.Nil(t, err, name)
+		assert.Equal(t, []byte("ICE ICE BABY"), unpadded, name)
+
+		for _, invalid := range []string{
+			"ICE ICE BABY\x05\x05\x05\x05",
+			"ICE ICE BABY\x01\x02\x03\x04",
+			"ICE ICE BABY\x04\x04\x04\x00",
+			"ICE ICE BABY\x04\x04\x04",
+			"ICE ICE BABY\x14\x14\x14\x14",
+			"",
+		} {
+			_, err := unpad([]byte(invalid), 16)
+			assert.Equal(t, ErrInvalidPadding, err, name+" %q", invalid)
+		}
+
+		for _, blockSize := range []int{0, -16, 256} {
+			_, err := unpad([]byte("ICE ICE BABY\x04\x04\x04\x04"), blockSize)
+			assert.Equal(t, ErrInvalidPadding, err, name+" block size %d", blockSize)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+CBC with ciphertext stealing
+
+Padding makes CBC ciphertexts longer than the plaintext. Ciphertext stealing keeps the length:
+the last partial plaintext block P_n* (d bytes) is zero padded and encrypted as usual, and then
+only the first d bytes of the second to last ciphertext block C_{n-1} are kept. They are not lost:
+decrypting C_n gives (P_n* || 0) xor C_{n-1}, whose last bytes are the missing end of C_{n-1}.
+
+The addendum to NIST SP 800-38A defines three orders for the last two blocks:
+
+	CS1: C_1 ... C_{n-2} || C_{n-1}* || C_n
+	CS2: like CS1 when the message is whole blocks, like CS3 otherwise
+	CS3: C_1 ... C_{n-2} || C_n || C_{n-1}*   (always swapped, this is the Kerberos variant of RFC 3962)
+
+Messages must be at least one block long; a single block is plain CBC.
+
+*/
+
+type CiphertextStealing int
+
+const (
+	CS1 CiphertextStealing = iota + 1
+	CS2
+	CS3
+)
+
+// swapped reports whether the variant puts C_n before C_{n-1}* for a final block of d bytes
+func (variant CiphertextStealing) swapped(d, blockSize int) bool {
+	switch variant {
+	case CS1:
+		return false
+	case CS2:
+		return d != blockSize
+	case CS3:
+		return true
+	}
+	panic("unknown ciphertext stealing variant")
+}
+
+// stealingSplit returns the size of the last, possibly partial, block
+func stealingSplit(length, blockSize int) (int, error) {
+	if length < blockSize {
+		return 0, errors.New("Ciphertext stealing needs at least one full block")
+	}
+	d := length % blockSize
+	if d == 0 {
+		d = blockSize
+	}
+	return d, nil
+}
+
+// EncryptCBCCS encrypts plainText of any length of at least one block; the ciphertext has the same length
+func EncryptCBCCS(block cipher.Block, plainText, IV []byte, variant CiphertextStealing) ([]byte, error) {
+	blockSize := block.BlockSize()
+	d, err := stealingSplit(len(plainText), blockSize)
+	if err != nil {
+		return nil, err
+	}
+	padded := make([]byte, len(plainText)+blockSize-d)
+	copy(padded, plainText)
+	full := EncryptCBC(block, padded, IV, NoPadding)
+	if len(full) == blockSize {
+		return full, nil
+	}
+
+	n := len(full)
+	previous := full[n-2*blockSize : n-2*blockSize+d]
+	last := full[n-blockSize:]
+	cipherText := append([]byte{}, full[:n-2*blockSize]...)
+	if variant.swapped(d, blockSize) {
+		return append(append(cipherText, last...), previous...), nil
+	}
+	return append(append(cipherText, previous...), last...), nil
+}
+
+// DecryptCBCCS reverses EncryptCBCCS
+func DecryptCBCCS(block cipher.Block, cipherText, IV []byte, variant CiphertextStealing) ([]byte, error) {
+	blockSize := block.BlockSize()
+	d, err := stealingSplit(len(cipherText), blockSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(cipherText) == blockSize {
+		return DecryptCBC(block, cipherText, IV, NoPadding)
+	}
+
+	n := len(cipherText)
+	var previous, last []byte
+	if variant.swapped(d, blockSize) {
+		last = cipherText[n-blockSize-d : n-d]
+		previous = cipherText[n-d:]
+	} else {
+		previous = cipherText[n-blockSize-d : n-blockSize]
+		last = cipherText[n-blockSize:]
+	}
+
+	// D(C_n) = (P_n* || 0) xor C_{n-1}: its tail completes C_{n-1}, its head gives P_n*
+	z := make([]byte, blockSize)
+	block.Decrypt(z, last)
+	fullPrevious := append(append([]byte{}, previous...), z[d:]...)
+	tail := make([]byte, d)
+	for i := range tail {
+		tail[i] = z[i] ^ fullPrevious[i]
+	}
+
+	head := append(append([]byte{}, cipherText[:n-blockSize-d]...), fullPrevious...)
+	plainText, err := DecryptCBC(block, head, IV, NoPadding)
+	if err != nil {
+		return nil, err
+	}
+	return append(plainText, tail...), nil
+}
+
+// RFC 3962 appendix B: AES-128, key "chicken teriyaki", zero IV, CBC-CS3
+var rfc3962Vectors = []struct{ plainText, cipherText string }{
+	{"4920776f756c64206c696b652074686520",
+		"c6353568f2bf8cb4d8a580362da7ff7f97"},
+	{"4920776f756c64206c696b65207468652047656e6572616c20476175277320",
+		"fc00783e0efdb2c1d445d4c8eff7ed2297687268d6ecccc0c07b25e25ecfe5"},
+	{"4920776f756c64206c696b65207468652047656e6572616c2047617527732043",
+		"39312523a78662d5be7fcbcc98ebf5a897687268d6ecccc0c07b25e25ecfe584"},
+	{"4920776f756c64206c696b65207468652047656e6572616c20476175277320436869636b656e2c20706c656173652c",
+		"97687268d6ecccc0c07b25e25ecfe584b3fffd940c16a18c1b5549d2f838029e39312523a78662d5be7fcbcc98ebf5"},
+	{"4920776f756c64206c696b65207468652047656e6572616c20476175277320436869636b656e2c20706c656173652c20",
+		"97687268d6ecccc0c07b25e25ecfe5849dad8bbb96c4cdc03bc103e1a194bbd839312523a78662d5be7fcbcc98ebf5a8"},
+	{"4920776f756c64206c696b65207468652047656e6572616c20476175277320436869636b656e2c20706c656173652c20616e6420776f6e746f6e20736f75702e",
+		"97687268d6ecccc0c07b25e25ecfe58439312523a78662d5be7fcbcc98ebf5a84807efe836ee89a526730dbc2f7bc8409dad8bbb96c4cdc03bc103e1a194bbd8"},
+}
+
+func TestCBCCS3RFC3962(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("chicken teriyaki"))
+	IV := make([]byte, 16)
+	for _, v := range rfc3962Vectors {
+		cipherText, err := EncryptCBCCS(block, mustDecodeHex(v.plainText), IV, CS3)
+		assert.Nil(t, err)
+		assert.Equal(t, v.cipherText, hex.EncodeToString(cipherText))
+
+		plainText, err := DecryptCBCCS(block, mustDecodeHex(v.cipherText), IV, CS3)
+		assert.Nil(t, err)
+		assert.Equal(t, v.plainText, hex.EncodeToString(plainText))
+	}
+}
+
+func TestCBCCS1CS2FromCS3(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("chicken teriyaki"))
+	IV := make([]byte, 16)
+	for _, v := range rfc3962Vectors {
+		plainText := mustDecodeHex(v.plainText)
+		cs3 := mustDecodeHex(v.cipherText)
+		d, _ := stealingSplit(len(cs3), 16)
+		n := len(cs3)
+
+		// CS1 is CS3 with the last two (partial) blocks back in CBC order
+		cs1 := append(append(append([]byte{}, cs3[:n-16-d]...), cs3[n-d:]...), cs3[n-16-d:n-d]...)
+		cs2 := cs3
+		if d == 16 {
+			cs2 = cs1
+			assert.Equal(t, EncryptCBC(block, plainText, IV, NoPadding), cs1)
+		}
+
+		for variant, expected := range map[CiphertextStealing][]byte{CS1: cs1, CS2: cs2} {
+			cipherText, err := EncryptCBCCS(block, plainText, IV, variant)
+			assert.Nil(t, err)
+			assert.Equal(t, expected, cipherText, "CS%d", variant)
+			decrypted, err := DecryptCBCCS(block, cipherText, IV, variant)
+			assert.Nil(t, err)
+			assert.Equal(t, plainText, decrypted, "CS%d", variant)
+		}
+	}
+}
+
+func TestCBCCSLengthPreserving(t *testing.T) {
+	aesBlock, _ := NewAESECBBlock(GenerateRandomAESKey())
+	desBlock, _ := NewDESCipher(GenerateRandomKey(8))
+	for _, block := range []cipher.Block{aesBlock, desBlock} {
+		blockSize := block.BlockSize()
+		IV := GenerateRandomKey(blockSize)
+		for size := blockSize; size <= 4*blockSize; size++ {
+			plainText := GenerateRandomKey(size)
+			for _, variant := range []CiphertextStealing{CS1, CS2, CS3} {
+				cipherText, err := EncryptCBCCS(block, plainText, IV, variant)
+				assert.Nil(t, err)
+				assert.Equal(t, size, len(cipherText))
+				decrypted, err := DecryptCBCCS(block, cipherText, IV, variant)
+				assert.Nil(t, err)
+				assert.Equal(t, plainText, decrypted)
+			}
+		}
+
+		_, err := EncryptCBCCS(block, make([]byte, blockSize-1), IV, CS3)
+		assert.NotNil(t, err)
+		_, err = DecryptCBCCS(block, make([]byte, blockSize-1), IV, CS3)
+		assert.NotNil(t, err)
+	}
+}
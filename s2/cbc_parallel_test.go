@@ -0,0 +1,220 @@
+package main
+
+import (
+	"crypto/cipher"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"s2/blockmode"
+)
+
+/*
+
+Fast CBC decryption
+
+DecryptCBCviaECB sets up the cipher on every call, allocates the plaintext and walks the blocks one after
+the other. CBC decryption does not have to be sequential though:
+
+	P_i = D(C_i) xor C_{i-1}
+
+only needs ciphertext blocks, so any range of blocks can be decrypted on its own, given the block
+before it, which is what blockmode.DecryptBlocks does. ParallelCBCDecrypter works on any cipher.Block, so
+NewAESECBBlock, which holds the expanded key, does the expansion once. It writes into a buffer the caller owns
+and splits large inputs into one range per CPU.
+
+Encryption stays sequential: C_i depends on C_{i-1}, which is not known before it is computed.
+
+*/
+
+// ParallelCBCThreshold is the ciphertext size from which ParallelCBCDecrypter spreads the work over goroutines
+const ParallelCBCThreshold = 64 * 1024
+
+type ParallelCBCDecrypter struct {
+	block   cipher.Block
+	workers int
+}
+
+// NewParallelCBCDecrypter decrypts with block, which must be safe for concurrent use (NewAESECBBlock blocks are)
+func NewParallelCBCDecrypter(block cipher.Block) *ParallelCBCDecrypter {
+	return &ParallelCBCDecrypter{block: block, workers: runtime.GOMAXPROCS(0)}
+}
+
+// DecryptInto decrypts cipherText into dst, which must hold len(cipherText) bytes and must not overlap cipherText,
+// and returns the PKCS#7 unpadded plaintext as a prefix of dst.
+// Below ParallelCBCThreshold it does not allocate; above, it pays for one goroutine per worker.
+func (d *ParallelCBCDecrypter) DecryptInto(dst, cipherText, IV []byte) ([]byte, error) {
+	blockSize := d.block.BlockSize()
+	if len(cipherText)%blockSize != 0 {
+		return nil, errors.New("DecryptInto: input not full blocks")
+	}
+	if len(IV) != blockSize {
+		return nil, errors.New("DecryptInto: IV length must equal block size")
+	}
+	if len(dst) < len(cipherText) {
+		return nil, errors.New("DecryptInto: output smaller than input")
+	}
+	dst = dst[:len(cipherText)]
+
+	if len(cipherText) < ParallelCBCThreshold || d.workers < 2 {
+		blockmode.DecryptBlocks(d.block, dst, cipherText, IV)
+	} else {
+		d.decryptParallel(dst, cipherText, IV)
+	}
+	return UnpadPKCS7(dst, blockSize)
+}
+
+// decryptParallel gives every worker its own range of blocks
+func (d *ParallelCBCDecrypter) decryptParallel(dst, cipherText, IV []byte) {
+	blockSize := d.block.BlockSize()
+	blocks := len(cipherText) / blockSize
+	chunk := (blocks + d.workers - 1) / d.workers * blockSize
+	var wg sync.WaitGroup
+	for start := 0; start < len(cipherText); start += chunk {
+		end := start + chunk
+		if end > len(cipherText) {
+			end = len(cipherText)
+		}
+		previous := IV
+		if start > 0 {
+			previous = cipherText[start-blockSize : start]
+		}
+		wg.Add(1)
+		go func(dst, cipherText, previous []byte) {
+			defer wg.Done()
+			blockmode.DecryptBlocks(d.block, dst, cipherText, previous)
+		}(dst[start:end], cipherText[start:end], previous)
+	}
+	wg.Wait()
+}
+
+func TestParallelCBCDecrypterMatchesDecryptCBCviaECB(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	IV := make([]byte, 16)
+	cipherText := ReadBase64File("10.txt")
+
+	expected, err := DecryptCBCviaECB(cipherText, key, IV)
+	assert.Nil(t, err)
+
+	block, err := NewAESECBBlock(key)
+	assert.Nil(t, err)
+	dst := make([]byte, len(cipherText))
+	plainText, err := NewParallelCBCDecrypter(block).DecryptInto(dst, cipherText, IV)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, plainText)
+}
+
+func TestParallelCBCDecrypterParallel(t *testing.T) {
+	key := GenerateRandomAESKey()
+	IV := GenerateRandomKey(16)
+	block, _ := NewAESECBBlock(key)
+
+	for _, size := range []int{ParallelCBCThreshold, ParallelCBCThreshold + 5, 1<<20 + 3} {
+		plainText := GenerateRandomKey(size)
+		cipherText := EncryptCBC(block, plainText, IV, PKCS7Padding)
+
+		for _, workers := range []int{1, 3, 8} {
+			decrypter := &ParallelCBCDecrypter{block: block, workers: workers}
+			decrypted, err := decrypter.DecryptInto(make([]byte, len(cipherText)), cipherText, IV)
+			assert.Nil(t, err)
+			assert.Equal(t, plainText, decrypted)
+		}
+	}
+}
+
+func cbcDecryptAllocs(t *testing.T, decrypter *ParallelCBCDecrypter, size int) float64 {
+	IV := make([]byte, 16)
+	cipherText := EncryptCBC(decrypter.block, GenerateRandomKey(size), IV, PKCS7Padding)
+	dst := make([]byte, len(cipherText))
+	return testing.AllocsPerRun(10, func() {
+		if _, err := decrypter.DecryptInto(dst, cipherText, IV); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestParallelCBCDecrypterSequentialDoesNotAllocate(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	decrypter := NewParallelCBCDecrypter(block)
+	assert.Equal(t, 0.0, cbcDecryptAllocs(t, decrypter, ParallelCBCThreshold-32))
+	// one worker never goes parallel, whatever the size
+	assert.Equal(t, 0.0, cbcDecryptAllocs(t, &ParallelCBCDecrypter{block: block, workers: 1}, 4*ParallelCBCThreshold))
+}
+
+func TestParallelCBCDecrypterParallelAllocatesPerWorker(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	decrypter := &ParallelCBCDecrypter{block: block, workers: 4}
+	// the goroutines and the WaitGroup cost the same for 64KB as for 1MB: nothing per block
+	small := cbcDecryptAllocs(t, decrypter, ParallelCBCThreshold)
+	large := cbcDecryptAllocs(t, decrypter, 1<<20)
+	assert.Greater(t, small, 0.0)
+	assert.LessOrEqual(t, large, small)
+	assert.LessOrEqual(t, small, float64(3*decrypter.workers+1))
+}
+
+func TestParallelCBCDecrypterErrors(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	decrypter := NewParallelCBCDecrypter(block)
+	_, err := decrypter.DecryptInto(make([]byte, 32), make([]byte, 17), make([]byte, 16))
+	assert.NotNil(t, err)
+	_, err = decrypter.DecryptInto(make([]byte, 16), make([]byte, 32), make([]byte, 16))
+	assert.NotNil(t, err)
+	_, err = decrypter.DecryptInto(make([]byte, 32), make([]byte, 32), make([]byte, 8))
+	assert.NotNil(t, err)
+	// a random last block is almost never valid padding
+	_, err = decrypter.DecryptInto(make([]byte, 32), GenerateRandomKey(32), make([]byte, 16))
+	assert.ErrorIs(t, err, ErrInvalidPadding)
+}
+
+const benchmarkCBCSize = 4 << 20
+
+func benchmarkCBCInput() ([]byte, []byte, []byte) {
+	key := []byte("YELLOW SUBMARINE")
+	IV := make([]byte, 16)
+	block, _ := NewAESECBBlock(key)
+	return key, IV, EncryptCBC(block, make([]byte, benchmarkCBCSize), IV, PKCS7Padding)
+}
+
+func BenchmarkDecryptCBCviaECB(b *testing.B) {
+	key, IV, cipherText := benchmarkCBCInput()
+	b.SetBytes(int64(len(cipherText)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecryptCBCviaECB(cipherText, key, IV); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParallelCBCDecrypterSequential(b *testing.B) {
+	key, IV, cipherText := benchmarkCBCInput()
+	block, _ := NewAESECBBlock(key)
+	decrypter := &ParallelCBCDecrypter{block: block, workers: 1}
+	dst := make([]byte, len(cipherText))
+	b.SetBytes(int64(len(cipherText)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decrypter.DecryptInto(dst, cipherText, IV); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParallelCBCDecrypterParallel(b *testing.B) {
+	key, IV, cipherText := benchmarkCBCInput()
+	block, _ := NewAESECBBlock(key)
+	decrypter := NewParallelCBCDecrypter(block)
+	dst := make([]byte, len(cipherText))
+	b.SetBytes(int64(len(cipherText)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := decrypter.DecryptInto(dst, cipherText, IV); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
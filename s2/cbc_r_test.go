@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+CBC-R: encrypting with a padding oracle
+
+A padding oracle does not only decrypt. RecoverIntermediate gives us D(C) for any block C we like,
+and in CBC the plaintext of C is D(C) XOR (previous block). So pick the last ciphertext block at random,
+learn its intermediate, and choose the previous block as intermediate XOR the plaintext we want.
+Repeat backwards until the first block: its "previous block" is the IV, which the attacker sends too.
+
+The result is an IV and ciphertext that decrypt, under a key we never learn, to a plaintext of our choosing.
+
+*/
+
+// ForgeCBC produces an IV and ciphertext that decrypt to plainText (PKCS#7 padded) using only a padding oracle.
+// It returns the number of oracle queries spent.
+func ForgeCBC(oracle PaddingOracle, blockSize int, plainText []byte) ([]byte, []byte, int, error) {
+	padded := PadPKCS7Block(append([]byte{}, plainText...), blockSize)
+	blocks := len(padded) / blockSize
+
+	// forged[0] is the IV, forged[i] the i-th ciphertext block
+	forged := make([]byte, (blocks+1)*blockSize)
+	copy(forged[blocks*blockSize:], GenerateRandomKey(blockSize))
+	queries := 0
+
+	for i := blocks; i > 0; i-- {
+		current := forged[i*blockSize : (i+1)*blockSize]
+		intermediate, q, err := RecoverIntermediate(oracle, blockSize, current)
+		queries += q
+		if err != nil {
+			return nil, nil, queries, err
+		}
+		previous := forged[(i-1)*blockSize : i*blockSize]
+		target := padded[(i-1)*blockSize : i*blockSize]
+		for j := range previous {
+			previous[j] = intermediate[j] ^ target[j]
+		}
+	}
+	return forged[:blockSize], forged[blockSize:], queries, nil
+}
+
+func TestForgeCBC(t *testing.T) {
+	server := NewCBCPaddingServer()
+	for _, plainText := range []string{"", "YELLOW SUBMARINE", "I'm back and I'm ringin' the bell"} {
+		IV, cipherText, _, err := ForgeCBC(server.ValidPadding, 16, []byte(plainText))
+		assert.Nil(t, err)
+		decrypted, err := server.Decrypt(IV, cipherText)
+		assert.Nil(t, err)
+		assert.Equal(t, plainText, string(decrypted))
+	}
+}
+
+func TestForgeAdminProfileCookie(t *testing.T) {
+	server := NewCBCPaddingServer()
+
+	// the cookie the server hands out quotes metacharacters, so we cannot ask it for an admin profile
+	IV, cookie := server.Encrypt([]byte(GenerateProfileFor("attacker@evil.com&role=admin")))
+	decrypted, err := server.Decrypt(IV, cookie)
+	assert.Nil(t, err)
+	assert.Equal(t, "user", ParseURLEncodedstring(string(decrypted))["role"])
+
+	// ... but the padding oracle lets us encrypt whatever we want
+	forgedProfile := "email=attacker@evil.com&uid=10&role=admin"
+	IV, cookie, queries, err := ForgeCBC(server.ValidPadding, 16, []byte(forgedProfile))
+	assert.Nil(t, err)
+	log.Printf("Forged a %d-byte cookie with %d oracle queries", len(cookie), queries)
+
+	decrypted, err = server.Decrypt(IV, cookie)
+	assert.Nil(t, err)
+	profile := ParseURLEncodedstring(string(decrypted))
+	assert.Equal(t, map[string]string{"email": "attacker@evil.com", "uid": "10", "role": "admin"}, profile)
+}
+
+func TestForgeCBC8ByteBlocks(t *testing.T) {
+	block, _ := NewDESCipher(GenerateRandomKey(8))
+	oracle := func(IV, cipherText []byte) bool {
+		_, err := DecryptCBC(block, cipherText, IV, PKCS7Padding)
+		return err == nil
+	}
+	IV, cipherText, _, err := ForgeCBC(oracle, 8, []byte("role=admin"))
+	assert.Nil(t, err)
+	decrypted, err := DecryptCBC(block, cipherText, IV, PKCS7Padding)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("role=admin"), decrypted)
+}
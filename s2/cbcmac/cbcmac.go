@@ -0,0 +1,65 @@
+/*
+Package cbcmac is CBC-MAC from challenges 49 and 50, and the glue block forgery that shows it is no hash.
+
+It is shared by the challenges and by the cbcmachash command, on top of the CBC mode in blockmode.
+*/
+package cbcmac
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"strings"
+
+	"s2/blockmode"
+)
+
+// State is the chaining value after CBC-encrypting message, which must be whole blocks, without padding
+func State(block cipher.Block, message, IV []byte) []byte {
+	cipherText := make([]byte, len(message))
+	blockmode.NewCBCEncrypter(block, IV).CryptBlocks(cipherText, message)
+	if len(cipherText) == 0 {
+		return append([]byte{}, IV...)
+	}
+	return cipherText[len(cipherText)-block.BlockSize():]
+}
+
+// MAC is the last block of the PKCS#7 padded CBC encryption of message
+func MAC(block cipher.Block, message, IV []byte) []byte {
+	blockSize := block.BlockSize()
+	padding := blockSize - len(message)%blockSize
+	padded := append(append([]byte{}, message...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	return State(block, padded, IV)
+}
+
+// ForgeCollision returns prefix || glue || original[blockSize:], which has the same zero-IV CBC-MAC as original.
+// prefix must be whole blocks; the glue block brings the chaining value back to where original's first block left it.
+func ForgeCollision(block cipher.Block, original, prefix []byte) []byte {
+	blockSize := block.BlockSize()
+	if len(prefix)%blockSize != 0 || len(original) < blockSize {
+		panic("ForgeCollision: prefix must be whole blocks and original at least one block")
+	}
+	state := State(block, prefix, make([]byte, blockSize))
+	forged := append([]byte{}, prefix...)
+	for i := 0; i < blockSize; i++ {
+		forged = append(forged, original[i]^state[i])
+	}
+	return append(forged, original[blockSize:]...)
+}
+
+// ForgeSnippet puts payload in front of a // comment that swallows the glue block and the rest of original.
+// The glue is random looking, so the comment is padded with spaces until the glue holds no line terminator
+// (JavaScript also ends lines at U+2028 and U+2029).
+func ForgeSnippet(block cipher.Block, original, payload string) []byte {
+	blockSize := block.BlockSize()
+	for spaces := 0; ; spaces++ {
+		prefix := []byte(payload + strings.Repeat(" ", spaces) + "//")
+		if len(prefix)%blockSize != 0 {
+			continue
+		}
+		forged := ForgeCollision(block, []byte(original), prefix)
+		glue := forged[len(prefix) : len(prefix)+blockSize]
+		if !bytes.ContainsAny(glue, "\n\r") && !bytes.Contains(glue, []byte("\u2028")) && !bytes.Contains(glue, []byte("\u2029")) {
+			return forged
+		}
+	}
+}
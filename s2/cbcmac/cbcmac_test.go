@@ -0,0 +1,40 @@
+package cbcmac
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const snippet = "alert('MZA who was that?');\n"
+
+func TestMACChallengeVector(t *testing.T) {
+	block, _ := aes.NewCipher([]byte("YELLOW SUBMARINE"))
+	assert.Equal(t, "296b8d7cb78a243dda4d0a61d33bbdd1", hex.EncodeToString(MAC(block, []byte(snippet), make([]byte, 16))))
+}
+
+func TestMACMatchesStdlibCBC(t *testing.T) {
+	block, _ := aes.NewCipher([]byte("YELLOW SUBMARINE"))
+	IV := []byte("0123456789abcdef")
+	padded := append([]byte("sixteen byte msg"), bytes.Repeat([]byte{16}, 16)...)
+	cipherText := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, IV).CryptBlocks(cipherText, padded)
+	assert.Equal(t, cipherText[16:], MAC(block, []byte("sixteen byte msg"), IV))
+	assert.Equal(t, IV, State(block, nil, IV))
+}
+
+func TestForgeSnippet(t *testing.T) {
+	block, _ := aes.NewCipher([]byte("YELLOW SUBMARINE"))
+	zeroIV := make([]byte, 16)
+	for _, payload := range []string{"alert('Ayo, the Wu is back!');", "console.log(1);"} {
+		forged := ForgeSnippet(block, snippet, payload)
+		assert.True(t, bytes.HasPrefix(forged, []byte(payload)))
+		assert.Equal(t, MAC(block, []byte(snippet), zeroIV), MAC(block, forged, zeroIV))
+		assert.Equal(t, 1, bytes.Count(forged, []byte("\n")))
+	}
+	assert.Panics(t, func() { ForgeCollision(block, []byte(snippet), []byte("short")) })
+}
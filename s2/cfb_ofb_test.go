@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/cipher"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"s2/blockmode"
+)
+
+/*
+
+CFB and OFB modes
+
+Two more of the classic SP 800-38A modes, built on the block encrypt function only (like CTR, neither needs
+the block decrypt function, and neither needs padding).
+
+CFB with an s-bit segment keeps a shift register that starts as the IV. For every segment, the register
+is encrypted, the leftmost s bits are XORed with the plaintext, and the resulting ciphertext segment is
+shifted into the register. CFB-1 works bit by bit, CFB-8 byte by byte, and CFB-128 a whole AES block at a time.
+
+OFB keeps encrypting its own output and uses it as the keystream; the plaintext never feeds back.
+
+*/
+
+// cfb runs CFB with a segmentBits-wide segment (1, or a multiple of 8 up to the block size)
+func cfb(block cipher.Block, IV []byte, segmentBits int, src []byte, decrypt bool) []byte {
+	blockSize := block.BlockSize()
+	if len(IV) != blockSize {
+		panic("CFB: IV length must equal block size")
+	}
+	if segmentBits != 1 && (segmentBits%8 != 0 || segmentBits <= 0 || segmentBits > 8*blockSize) {
+		panic("CFB: segment size must be 1 bit or a whole number of bytes up to the block size")
+	}
+
+	register := append([]byte{}, IV...)
+	output := make([]byte, blockSize)
+	dst := make([]byte, len(src))
+
+	if segmentBits == 1 {
+		for i := range src {
+			for bit := 7; bit >= 0; bit-- {
+				block.Encrypt(output, register)
+				in := src[i] >> uint(bit) & 1
+				out := in ^ output[0]>>7
+				dst[i] |= out << uint(bit)
+
+				feedback := out
+				if decrypt {
+					feedback = in
+				}
+				shiftLeftOneBit(register, feedback)
+			}
+		}
+		return dst
+	}
+
+	segment := segmentBits / 8
+	for i := 0; i < len(src); i += segment {
+		end := i + segment
+		if end > len(src) {
+			end = len(src)
+		}
+		block.Encrypt(output, register)
+		for j := i; j < end; j++ {
+			dst[j] = src[j] ^ output[j-i]
+		}
+		feedback := dst[i:end]
+		if decrypt {
+			feedback = src[i:end]
+		}
+		copy(register, register[segment:])
+		copy(register[blockSize-segment:], feedback)
+	}
+	return dst
+}
+
+// shiftLeftOneBit shifts the whole register one bit to the left and puts bit in the lowest position
+func shiftLeftOneBit(register []byte, bit byte) {
+	for i := 0; i < len(register)-1; i++ {
+		register[i] = register[i]<<1 | register[i+1]>>7
+	}
+	register[len(register)-1] = register[len(register)-1]<<1 | bit
+}
+
+func EncryptCFB(block cipher.Block, IV []byte, segmentBits int, plainText []byte) []byte {
+	return cfb(block, IV, segmentBits, plainText, false)
+}
+
+func DecryptCFB(block cipher.Block, IV []byte, segmentBits int, cipherText []byte) []byte {
+	return cfb(block, IV, segmentBits, cipherText, true)
+}
+
+// OFB encrypts or decrypts src; the operation is its own inverse
+func OFB(block cipher.Block, IV []byte, src []byte) []byte {
+	blockSize := block.BlockSize()
+	if len(IV) != blockSize {
+		panic("OFB: IV length must equal block size")
+	}
+	keyStream := append([]byte{}, IV...)
+	dst := make([]byte, len(src))
+	for i := range src {
+		if i%blockSize == 0 {
+			block.Encrypt(keyStream, keyStream)
+		}
+		dst[i] = src[i] ^ keyStream[i%blockSize]
+	}
+	return dst
+}
+
+func TestCFBSP80038A(t *testing.T) {
+	block, _ := NewAESECBBlock(mustDecodeHex(blockmode.SP80038AKey))
+	IV := mustDecodeHex(blockmode.SP80038AIV)
+	plainText := mustDecodeHex(blockmode.SP80038APlainText)
+
+	vectors := []struct {
+		name        string
+		segmentBits int
+		plainText   []byte
+		cipherText  string
+	}{
+		// F.3.1 lists 16 bits, 0110101111000001 -> 0110100010110011
+		{"F.3.1 CFB1-AES128", 1, plainText[:2], "68b3"},
+		{"F.3.7 CFB8-AES128", 8, plainText[:18], "3b79424c9c0dd436bace9e0ed4586a4f32b9"},
+		{"F.3.13 CFB128-AES128", 128, plainText, "3b3fd92eb72dad20333449f8e83cfb4a" + "c8a64537a0b3a93fcde3cdad9f1ce58b" +
+			"26751f67a3cbb140b1808cf187a4f4df" + "c04b05357c5d1c0eeac4c66f9ff7f2e6"},
+	}
+	for _, v := range vectors {
+		cipherText := EncryptCFB(block, IV, v.segmentBits, v.plainText)
+		assert.Equal(t, v.cipherText, hex.EncodeToString(cipherText), v.name)
+		assert.Equal(t, v.plainText, DecryptCFB(block, IV, v.segmentBits, cipherText), v.name)
+	}
+}
+
+func TestOFBSP80038A(t *testing.T) {
+	block, _ := NewAESECBBlock(mustDecodeHex(blockmode.SP80038AKey))
+	IV := mustDecodeHex(blockmode.SP80038AIV)
+	plainText := mustDecodeHex(blockmode.SP80038APlainText)
+
+	// F.4.1 OFB-AES128
+	expected := "3b3fd92eb72dad20333449f8e83cfb4a" + "7789508d16918f03f53c52dac54ed825" +
+		"9740051e9c5fecf64344f7a82260edcc" + "304c6528f659c77866a510d9c1d6ae5e"
+	cipherText := OFB(block, IV, plainText)
+	assert.Equal(t, expected, hex.EncodeToString(cipherText))
+	assert.Equal(t, plainText, OFB(block, IV, cipherText))
+}
+
+func TestCFBOFBPartialAndSmallBlocks(t *testing.T) {
+	aesBlock, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	desBlock, _ := NewDESCipher([]byte("SUBMARIN"))
+	plainText := []byte("Quick to the point, to the point, no faking")
+
+	for _, block := range []cipher.Block{aesBlock, desBlock} {
+		IV := GenerateRandomKey(block.BlockSize())
+		for _, segmentBits := range []int{1, 8, 32, 8 * block.BlockSize()} {
+			cipherText := EncryptCFB(block, IV, segmentBits, plainText)
+			assert.Equal(t, len(plainText), len(cipherText))
+			assert.Equal(t, plainText, DecryptCFB(block, IV, segmentBits, cipherText))
+		}
+		assert.Equal(t, plainText, OFB(block, IV, OFB(block, IV, plainText)))
+	}
+
+	assert.Panics(t, func() { EncryptCFB(aesBlock, make([]byte, 16), 12, plainText) })
+	assert.Panics(t, func() { EncryptCFB(aesBlock, make([]byte, 16), 256, plainText) })
+}
@@ -0,0 +1,72 @@
+/*
+CBC-MAC as a hash
+
+Challenge 50: with a public key and a zero IV, CBC-MAC is no hash. This command forges a JavaScript snippet
+that alerts a different message but has the same CBC-MAC as alert('MZA who was that?');\n, then prints
+both snippets with their tags.
+
+Usage:
+
+	go run ./cmd/cbcmachash
+	go run ./cmd/cbcmachash -payload "alert('Ayo, the Wu is back!');" -o forged.js
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"s2/blockmode"
+	"s2/cbcmac"
+)
+
+const original = "alert('MZA who was that?');\n"
+
+// Forge builds the snippet that runs payload under the tag of original, writes both snippets and their tags
+// to w, and fails if the tags differ
+func Forge(w io.Writer, key []byte, payload string) ([]byte, error) {
+	block, err := blockmode.NewAESECBBlock(key)
+	if err != nil {
+		return nil, err
+	}
+
+	zeroIV := make([]byte, block.BlockSize())
+	forged := cbcmac.ForgeSnippet(block, original, payload)
+	originalTag := cbcmac.MAC(block, []byte(original), zeroIV)
+	forgedTag := cbcmac.MAC(block, forged, zeroIV)
+
+	fmt.Fprintf(w, "Original: %q\n", original)
+	fmt.Fprintf(w, "Tag:      %s\n", hex.EncodeToString(originalTag))
+	fmt.Fprintf(w, "Forged:   %q\n", forged)
+	fmt.Fprintf(w, "Tag:      %s\n", hex.EncodeToString(forgedTag))
+	if !bytes.Equal(originalTag, forgedTag) {
+		return nil, errors.New("Tags differ")
+	}
+	fmt.Fprintln(w, "Tags match")
+	return forged, nil
+}
+
+func main() {
+	key := flag.String("key", "YELLOW SUBMARINE", "16-byte AES key")
+	payload := flag.String("payload", "alert('Ayo, the Wu is back!');", "JavaScript to run instead")
+	output := flag.String("o", "", "also write the forged snippet to this file")
+	flag.Parse()
+
+	forged, err := Forge(os.Stdout, []byte(*key), *payload)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *output != "" {
+		if err := os.WriteFile(*output, forged, 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %s", *output)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForge(t *testing.T) {
+	for _, payload := range []string{"alert('Ayo, the Wu is back!');", "console.log(1);"} {
+		var out bytes.Buffer
+		forged, err := Forge(&out, []byte("YELLOW SUBMARINE"), payload)
+		assert.Nil(t, err)
+		assert.True(t, bytes.HasPrefix(forged, []byte(payload)))
+		assert.Equal(t, 1, bytes.Count(forged, []byte("\n")))
+		// the challenge's tag for the original snippet, printed twice
+		assert.Equal(t, 2, bytes.Count(out.Bytes(), []byte("296b8d7cb78a243dda4d0a61d33bbdd1")))
+		assert.Contains(t, out.String(), "Tags match")
+	}
+}
+
+func TestForgeRejectsBadKey(t *testing.T) {
+	_, err := Forge(&bytes.Buffer{}, []byte("SUBMARINE"), "console.log(1);")
+	assert.NotNil(t, err)
+}
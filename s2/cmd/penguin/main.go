@@ -0,0 +1,224 @@
+/*
+ECB penguin
+
+"Lots of people know that when you encrypt something in ECB mode, you can see penguins through it."
+
+This command shows it. It reads an uncompressed image (binary PPM or BI_RGB BMP), encrypts the pixel data
+with AES-128 in ECB and in CBC mode while keeping the header intact, and writes both results as images
+that any viewer opens. It also writes a heatmap of ECB block repetition: blocks that occur once are black,
+blocks that repeat are brighter the more often they repeat.
+
+Usage:
+
+	go run ./cmd/penguin -in tux.ppm
+
+writes tux.ecb.ppm, tux.cbc.ppm and tux.heatmap.ppm next to the input.
+*/
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"s1/ecbdetect"
+	"s2/blockmode"
+)
+
+const blockSize = 16
+
+// Image is a raw image split into an untouched header and the pixel data we encrypt
+type Image struct {
+	Format string
+	Header []byte
+	Pixels []byte
+}
+
+func (img Image) Bytes() []byte {
+	return append(append([]byte{}, img.Header...), img.Pixels...)
+}
+
+func (img Image) WithPixels(pixels []byte) Image {
+	return Image{Format: img.Format, Header: img.Header, Pixels: pixels}
+}
+
+// ParseImage splits a binary PPM (P6) or an uncompressed BMP into header and pixel data
+func ParseImage(buf []byte) (Image, error) {
+	switch {
+	case bytes.HasPrefix(buf, []byte("P6")):
+		return parsePPM(buf)
+	case bytes.HasPrefix(buf, []byte("BM")):
+		return parseBMP(buf)
+	}
+	return Image{}, errors.New("Unsupported image format, expected binary PPM (P6) or BMP")
+}
+
+func parsePPM(buf []byte) (Image, error) {
+	// header: magic, width, height, maxval, separated by whitespace and optional # comments,
+	// followed by exactly one whitespace character before the pixel data
+	pos := 0
+	fields := make([]string, 0, 4)
+	for len(fields) < 4 {
+		for pos < len(buf) && (isSpace(buf[pos]) || buf[pos] == '#') {
+			if buf[pos] == '#' {
+				for pos < len(buf) && buf[pos] != '\n' {
+					pos++
+				}
+			} else {
+				pos++
+			}
+		}
+		start := pos
+		for pos < len(buf) && !isSpace(buf[pos]) {
+			pos++
+		}
+		if start == pos {
+			return Image{}, errors.New("Truncated PPM header")
+		}
+		fields = append(fields, string(buf[start:pos]))
+	}
+	for _, field := range fields[1:] {
+		if _, err := strconv.Atoi(field); err != nil {
+			return Image{}, fmt.Errorf("Malformed PPM header: %w", err)
+		}
+	}
+	if pos >= len(buf) {
+		return Image{}, errors.New("PPM has no pixel data")
+	}
+	pos++
+	return Image{Format: "ppm", Header: buf[:pos], Pixels: buf[pos:]}, nil
+}
+
+// BMP headers: the 14-byte file header, then an info header that starts with its own size.
+// BITMAPINFOHEADER (40 bytes) and its successors have the compression field; BITMAPCOREHEADER (12 bytes) does not.
+const (
+	bmpFileHeaderSize = 14
+	bmpInfoHeaderSize = 40
+)
+
+func parseBMP(buf []byte) (Image, error) {
+	if len(buf) < bmpFileHeaderSize+4 {
+		return Image{}, errors.New("Truncated BMP header")
+	}
+	infoSize := binary.LittleEndian.Uint32(buf[14:18])
+	if infoSize < bmpInfoHeaderSize {
+		return Image{}, fmt.Errorf("BMP info header of %d bytes is not supported, expected BITMAPINFOHEADER or later", infoSize)
+	}
+	headerSize := uint64(bmpFileHeaderSize) + uint64(infoSize)
+	if uint64(len(buf)) < headerSize {
+		return Image{}, errors.New("Truncated BMP header")
+	}
+	offset := binary.LittleEndian.Uint32(buf[10:14])
+	if uint64(offset) < headerSize {
+		return Image{}, errors.New("BMP pixel data offset is inside the header")
+	}
+	compression := binary.LittleEndian.Uint32(buf[30:34])
+	if compression != 0 {
+		return Image{}, fmt.Errorf("Compressed BMP (method %d) is not supported", compression)
+	}
+	if int(offset) > len(buf) {
+		return Image{}, errors.New("BMP pixel data offset is past the end of file")
+	}
+	return Image{Format: "bmp", Header: buf[:offset], Pixels: buf[offset:]}, nil
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+// EncryptECB encrypts every full block; a trailing partial block is left as is so the image keeps its size
+func EncryptECB(data []byte, block cipher.Block) []byte {
+	result := append([]byte{}, data...)
+	full := len(data) / block.BlockSize() * block.BlockSize()
+	blockmode.NewECBEncrypter(block).CryptBlocks(result[:full], data[:full])
+	return result
+}
+
+// EncryptCBC chains every full block through the ECB primitive; a trailing partial block is left as is
+func EncryptCBC(data []byte, block cipher.Block, IV []byte) []byte {
+	result := append([]byte{}, data...)
+	full := len(data) / block.BlockSize() * block.BlockSize()
+	blockmode.NewCBCEncrypter(block, IV).CryptBlocks(result[:full], data[:full])
+	return result
+}
+
+// RepetitionHeatmap replaces every byte of a block with a brightness proportional to how often that block occurs
+func RepetitionHeatmap(data []byte) []byte {
+	report := ecbdetect.AnalyzeBlockRepetition(data, blockSize)
+	maxCount := 1
+	for _, repeated := range report.RepeatedBlocks {
+		if len(repeated.Indices) > maxCount {
+			maxCount = len(repeated.Indices)
+		}
+	}
+
+	heatmap := make([]byte, len(data))
+	for _, repeated := range report.RepeatedBlocks {
+		// repeated blocks start at a visible gray and go up to white
+		level := byte(64 + 191*(len(repeated.Indices)-1)/maxCount)
+		for _, index := range repeated.Indices {
+			for j := index * blockSize; j < (index+1)*blockSize; j++ {
+				heatmap[j] = level
+			}
+		}
+	}
+	return heatmap
+}
+
+func outputName(input, suffix string) string {
+	ext := filepath.Ext(input)
+	return strings.TrimSuffix(input, ext) + "." + suffix + ext
+}
+
+func main() {
+	input := flag.String("in", "", "uncompressed PPM (P6) or BMP image")
+	key := flag.String("key", "YELLOW SUBMARINE", "16-byte AES key")
+	flag.Parse()
+
+	if *input == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if len(*key) != blockSize {
+		log.Fatalf("Key must be exactly %d bytes", blockSize)
+	}
+
+	buf, err := os.ReadFile(*input)
+	if err != nil {
+		log.Fatal(err)
+	}
+	img, err := ParseImage(buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	block, err := blockmode.NewAESECBBlock([]byte(*key))
+	if err != nil {
+		log.Fatal(err)
+	}
+	IV := make([]byte, blockSize)
+	cryptorand.Read(IV)
+	ecb := EncryptECB(img.Pixels, block)
+
+	outputs := map[string][]byte{
+		"ecb":     img.WithPixels(ecb).Bytes(),
+		"cbc":     img.WithPixels(EncryptCBC(img.Pixels, block, IV)).Bytes(),
+		"heatmap": img.WithPixels(RepetitionHeatmap(ecb)).Bytes(),
+	}
+	for _, suffix := range []string{"ecb", "cbc", "heatmap"} {
+		name := outputName(*input, suffix)
+		if err := os.WriteFile(name, outputs[suffix], 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %s", name)
+	}
+}
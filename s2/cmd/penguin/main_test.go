@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"s1/ecbdetect"
+	"s2/blockmode"
+)
+
+// a flat-colored image with a stripe: the kind of picture where ECB shows through
+func stripedPixels(width, height int) []byte {
+	pixels := make([]byte, 0, width*height*3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x > width/3 && x < 2*width/3 {
+				pixels = append(pixels, 0, 0, 0)
+			} else {
+				pixels = append(pixels, 255, 255, 255)
+			}
+		}
+	}
+	return pixels
+}
+
+func TestParsePPM(t *testing.T) {
+	pixels := stripedPixels(64, 32)
+	header := []byte("P6\n# a comment\n64 32\n255\n")
+	img, err := ParseImage(append(append([]byte{}, header...), pixels...))
+	assert.Nil(t, err)
+	assert.Equal(t, "ppm", img.Format)
+	assert.Equal(t, header, img.Header)
+	assert.Equal(t, pixels, img.Pixels)
+
+	_, err = ParseImage([]byte("P6\n64"))
+	assert.NotNil(t, err)
+}
+
+func TestParseBMP(t *testing.T) {
+	header := make([]byte, 54)
+	copy(header, "BM")
+	binary.LittleEndian.PutUint32(header[10:14], 54)
+	binary.LittleEndian.PutUint32(header[14:18], 40)
+	pixels := stripedPixels(16, 16)
+
+	img, err := ParseImage(append(append([]byte{}, header...), pixels...))
+	assert.Nil(t, err)
+	assert.Equal(t, "bmp", img.Format)
+	assert.Equal(t, pixels, img.Pixels)
+
+	// BITMAPV5HEADER is a BITMAPINFOHEADER with more fields after it
+	v5 := append(append([]byte{}, header...), make([]byte, 124-40)...)
+	binary.LittleEndian.PutUint32(v5[10:14], 138)
+	binary.LittleEndian.PutUint32(v5[14:18], 124)
+	img, err = ParseImage(append(v5, pixels...))
+	assert.Nil(t, err)
+	assert.Equal(t, pixels, img.Pixels)
+
+	malformed := map[string]func([]byte){
+		"RLE8":             func(h []byte) { binary.LittleEndian.PutUint32(h[30:34], 1) },
+		"BITMAPCOREHEADER": func(h []byte) { binary.LittleEndian.PutUint32(h[14:18], 12) },
+		"no info size":     func(h []byte) { binary.LittleEndian.PutUint32(h[14:18], 0) },
+		"info past EOF":    func(h []byte) { binary.LittleEndian.PutUint32(h[14:18], 0xffffffff) },
+		"offset in header": func(h []byte) { binary.LittleEndian.PutUint32(h[10:14], 30) },
+	}
+	for name, corrupt := range malformed {
+		bad := append([]byte{}, header...)
+		corrupt(bad)
+		_, err = ParseImage(append(bad, pixels...))
+		assert.NotNil(t, err, name)
+	}
+	for _, length := range []int{2, 17, 33} {
+		_, err = ParseImage(header[:length])
+		assert.NotNil(t, err, "truncated to %d bytes", length)
+	}
+
+	_, err = ParseImage([]byte("GIF89a"))
+	assert.NotNil(t, err)
+}
+
+func TestECBShowsThePenguin(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	img := Image{Format: "ppm", Header: []byte(fmt.Sprintf("P6\n%d %d\n255\n", 64, 32)), Pixels: stripedPixels(64, 32)}
+
+	block, err := blockmode.NewAESECBBlock(key)
+	assert.Nil(t, err)
+	ecb := EncryptECB(img.Pixels, block)
+	cbc := EncryptCBC(img.Pixels, block, make([]byte, blockSize))
+
+	assert.Equal(t, len(img.Pixels), len(ecb))
+	assert.Equal(t, len(img.Pixels), len(cbc))
+	assert.True(t, bytes.HasPrefix(img.WithPixels(ecb).Bytes(), img.Header))
+	// most ECB blocks repeat an earlier one, no CBC block does
+	assert.Greater(t, ecbdetect.AnalyzeBlockRepetition(ecb, blockSize).Score, 0.5)
+	assert.Equal(t, 0.0, ecbdetect.AnalyzeBlockRepetition(cbc, blockSize).Score)
+
+	heatmap := RepetitionHeatmap(ecb)
+	assert.Equal(t, len(ecb), len(heatmap))
+	assert.NotEqual(t, byte(0), heatmap[0])
+	assert.Equal(t, make([]byte, len(cbc)), RepetitionHeatmap(cbc))
+}
+
+func TestOutputName(t *testing.T) {
+	assert.Equal(t, "img/tux.ecb.ppm", outputName("img/tux.ppm", "ecb"))
+}
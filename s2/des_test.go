@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+DES, Triple-DES and meet-in-the-middle
+
+DES is the classic 8-byte block cipher (FIPS 46-3). Having it lets us run guessBlockSize,
+DetectECB and the byte-at-a-time attacks against something that is not 16-byte AES.
+
+Triple-DES (SP 800-67) chains three DES operations as Encrypt-Decrypt-Encrypt:
+EDE3 uses three independent keys, EDE2 reuses the first key as the third.
+
+Why not just "Double DES"? Because encrypting twice under k1 and k2 only costs an attacker
+about 2 * 2^n operations instead of 2^(2n): encrypt the known plaintext under every k1,
+decrypt the ciphertext under every k2, and look for a match in the middle.
+We demonstrate that below with a deliberately reduced key space.
+
+*/
+
+const DESBlockSize = 8
+
+var desInitialPermutation = []byte{
+	58, 50, 42, 34, 26, 18, 10, 2,
+	60, 52, 44, 36, 28, 20, 12, 4,
+	62, 54, 46, 38, 30, 22, 14, 6,
+	64, 56, 48, 40, 32, 24, 16, 8,
+	57, 49, 41, 33, 25, 17, 9, 1,
+	59, 51, 43, 35, 27, 19, 11, 3,
+	61, 53, 45, 37, 29, 21, 13, 5,
+	63, 55, 47, 39, 31, 23, 15, 7,
+}
+
+var desFinalPermutation = []byte{
+	40, 8, 48, 16, 56, 24, 64, 32,
+	39, 7, 47, 15, 55, 23, 63, 31,
+	38, 6, 46, 14, 54, 22, 62, 30,
+	37, 5, 45, 13, 53, 21, 61, 29,
+	36, 4, 44, 12, 52, 20, 60, 28,
+	35, 3, 43, 11, 51, 19, 59, 27,
+	34, 2, 42, 10, 50, 18, 58, 26,
+	33, 1, 41, 9, 49, 17, 57, 25,
+}
+
+var desExpansion = []byte{
+	32, 1, 2, 3, 4, 5,
+	4, 5, 6, 7, 8, 9,
+	8, 9, 10, 11, 12, 13,
+	12, 13, 14, 15, 16, 17,
+	16, 17, 18, 19, 20, 21,
+	20, 21, 22, 23, 24, 25,
+	24, 25, 26, 27, 28, 29,
+	28, 29, 30, 31, 32, 1,
+}
+
+var desPermutation = []byte{
+	16, 7, 20, 21, 29, 12, 28, 17,
+	1, 15, 23, 26, 5, 18, 31, 10,
+	2, 8, 24, 14, 32, 27, 3, 9,
+	19, 13, 30, 6, 22, 11, 4, 25,
+}
+
+var desPermutedChoice1 = []byte{
+	57, 49, 41, 33, 25, 17, 9,
+	1, 58, 50, 42, 34, 26, 18,
+	10, 2, 59, 51, 43, 35, 27,
+	19, 11, 3, 60, 52, 44, 36,
+	63, 55, 47, 39, 31, 23, 15,
+	7, 62, 54, 46, 38, 30, 22,
+	14, 6, 61, 53, 45, 37, 29,
+	21, 13, 5, 28, 20, 12, 4,
+}
+
+var desPermutedChoice2 = []byte{
+	14, 17, 11, 24, 1, 5,
+	3, 28, 15, 6, 21, 10,
+	23, 19, 12, 4, 26, 8,
+	16, 7, 27, 20, 13, 2,
+	41, 52, 31, 37, 47, 55,
+	30, 40, 51, 45, 33, 48,
+	44, 49, 39, 56, 34, 53,
+	46, 42, 50, 36, 29, 32,
+}
+
+var desKeyShifts = []uint{1, 1, 2, 2, 2, 2, 2, 2, 1, 2, 2, 2, 2, 2, 2, 1}
+
+var desSBoxes = [8][64]byte{
+	{
+		14, 4, 13, 1, 2, 15, 11, 8, 3, 10, 6, 12, 5, 9, 0, 7,
+		0, 15, 7, 4, 14, 2, 13, 1, 10, 6, 12, 11, 9, 5, 3, 8,
+		4, 1, 14, 8, 13, 6, 2, 11, 15, 12, 9, 7, 3, 10, 5, 0,
+		15, 12, 8, 2, 4, 9, 1, 7, 5, 11, 3, 14, 10, 0, 6, 13,
+	},
+	{
+		15, 1, 8, 14, 6, 11, 3, 4, 9, 7, 2, 13, 12, 0, 5, 10,
+		3, 13, 4, 7, 15, 2, 8, 14, 12, 0, 1, 10, 6, 9, 11, 5,
+		0, 14, 7, 11, 10, 4, 13, 1, 5, 8, 12, 6, 9, 3, 2, 15,
+		13, 8, 10, 1, 3, 15, 4, 2, 11, 6, 7, 12, 0, 5, 14, 9,
+	},
+	{
+		10, 0, 9, 14, 6, 3, 15, 5, 1, 13, 12, 7, 11, 4, 2, 8,
+		13, 7, 0, 9, 3, 4, 6, 10, 2, 8, 5, 14, 12, 11, 15, 1,
+		13, 6, 4, 9, 8, 15, 3, 0, 11, 1, 2, 12, 5, 10, 14, 7,
+		1, 10, 13, 0, 6, 9, 8, 7, 4, 15, 14, 3, 11, 5, 2, 12,
+	},
+	{
+		7, 13, 14, 3, 0, 6, 9, 10, 1, 2, 8, 5, 11, 12, 4, 15,
+		13, 8, 11, 5, 6, 15, 0, 3, 4, 7, 2, 12, 1, 10, 14, 9,
+		10, 6, 9, 0, 12, 11, 7, 13, 15, 1, 3, 14, 5, 2, 8, 4,
+		3, 15, 0, 6, 10, 1, 13, 8, 9, 4, 5, 11, 12, 7, 2, 14,
+	},
+	{
+		2, 12, 4, 1, 7, 10, 11, 6, 8, 5, 3, 15, 13, 0, 14, 9,
+		14, 11, 2, 12, 4, 7, 13, 1, 5, 0, 15, 10, 3, 9, 8, 6,
+		4, 2, 1, 11, 10, 13, 7, 8, 15, 9, 12, 5, 6, 3, 0, 14,
+		11, 8, 12, 7, 1, 14, 2, 13, 6, 15, 0, 9, 10, 4, 5, 3,
+	},
+	{
+		12, 1, 10, 15, 9, 2, 6, 8, 0, 13, 3, 4, 14, 7, 5, 11,
+		10, 15, 4, 2, 7, 12, 9, 5, 6, 1, 13, 14, 0, 11, 3, 8,
+		9, 14, 15, 5, 2, 8, 12, 3, 7, 0, 4, 10, 1, 13, 11, 6,
+		4, 3, 2, 12, 9, 5, 15, 10, 11, 14, 1, 7, 6, 0, 8, 13,
+	},
+	{
+		4, 11, 2, 14, 15, 0, 8, 13, 3, 12, 9, 7, 5, 10, 6, 1,
+		13, 0, 11, 7, 4, 9, 1, 10, 14, 3, 5, 12, 2, 15, 8, 6,
+		1, 4, 11, 13, 12, 3, 7, 14, 10, 15, 6, 8, 0, 5, 9, 2,
+		6, 11, 13, 8, 1, 4, 10, 7, 9, 5, 0, 15, 14, 2, 3, 12,
+	},
+	{
+		13, 2, 8, 4, 6, 15, 11, 1, 10, 9, 3, 14, 5, 0, 12, 7,
+		1, 15, 13, 8, 10, 3, 7, 4, 12, 5, 6, 11, 0, 14, 9, 2,
+		7, 11, 4, 1, 9, 12, 14, 2, 0, 6, 10, 13, 15, 3, 5, 8,
+		2, 1, 14, 7, 4, 10, 8, 13, 15, 12, 9, 0, 3, 5, 6, 11,
+	},
+}
+
+// permuteBits picks bits of an inBits-wide value according to a 1-based (MSB first) permutation table
+func permuteBits(input uint64, inBits int, table []byte) uint64 {
+	var output uint64
+	for _, position := range table {
+		output = output<<1 | (input>>(uint(inBits)-uint(position)))&1
+	}
+	return output
+}
+
+func rotateLeft28(x uint32, n uint) uint32 {
+	return (x<<n | x>>(28-n)) & 0x0fffffff
+}
+
+type desCipher struct {
+	subKeys [16]uint64
+}
+
+// NewDESCipher creates a hand-written DES block cipher; key must be 8 bytes (parity bits are ignored)
+func NewDESCipher(key []byte) (cipher.Block, error) {
+	if len(key) != 8 {
+		return nil, des.KeySizeError(len(key))
+	}
+	c := &desCipher{}
+	c.expandKey(key)
+	return c, nil
+}
+
+func (c *desCipher) expandKey(key []byte) {
+	permuted := permuteBits(binary.BigEndian.Uint64(key), 64, desPermutedChoice1)
+	left := uint32(permuted>>28) & 0x0fffffff
+	right := uint32(permuted) & 0x0fffffff
+	for round, shift := range desKeyShifts {
+		left = rotateLeft28(left, shift)
+		right = rotateLeft28(right, shift)
+		c.subKeys[round] = permuteBits(uint64(left)<<28|uint64(right), 56, desPermutedChoice2)
+	}
+}
+
+func desFeistel(right uint32, subKey uint64) uint32 {
+	expanded := permuteBits(uint64(right), 32, desExpansion) ^ subKey
+	var substituted uint32
+	for i := 0; i < 8; i++ {
+		chunk := byte(expanded>>(42-6*uint(i))) & 0x3f
+		row := chunk>>4&2 | chunk&1
+		column := chunk >> 1 & 0x0f
+		substituted = substituted<<4 | uint32(desSBoxes[i][row*16+column])
+	}
+	return uint32(permuteBits(uint64(substituted), 32, desPermutation))
+}
+
+func (c *desCipher) crypt(dst, src []byte, decrypt bool) {
+	block := permuteBits(binary.BigEndian.Uint64(src), 64, desInitialPermutation)
+	left, right := uint32(block>>32), uint32(block)
+	for round := 0; round < 16; round++ {
+		subKey := c.subKeys[round]
+		if decrypt {
+			subKey = c.subKeys[15-round]
+		}
+		left, right = right, left^desFeistel(right, subKey)
+	}
+	// the halves are swapped one last time before the final permutation
+	block = uint64(right)<<32 | uint64(left)
+	binary.BigEndian.PutUint64(dst, permuteBits(block, 64, desFinalPermutation))
+}
+
+func (c *desCipher) BlockSize() int { return DESBlockSize }
+
+func (c *desCipher) Encrypt(dst, src []byte) { c.crypt(dst, src, false) }
+
+func (c *desCipher) Decrypt(dst, src []byte) { c.crypt(dst, src, true) }
+
+type tripleDESCipher struct {
+	c1, c2, c3 cipher.Block
+}
+
+// NewTripleDESCipher creates EDE3 from a 24-byte key or EDE2 (k3 = k1) from a 16-byte key
+func NewTripleDESCipher(key []byte) (cipher.Block, error) {
+	switch len(key) {
+	case 16:
+		key = append(append([]byte{}, key...), key[:8]...)
+	case 24:
+	default:
+		return nil, des.KeySizeError(len(key))
+	}
+	c1, _ := NewDESCipher(key[0:8])
+	c2, _ := NewDESCipher(key[8:16])
+	c3, _ := NewDESCipher(key[16:24])
+	return &tripleDESCipher{c1, c2, c3}, nil
+}
+
+func (c *tripleDESCipher) BlockSize() int { return DESBlockSize }
+
+func (c *tripleDESCipher) Encrypt(dst, src []byte) {
+	buf := make([]byte, DESBlockSize)
+	c.c1.Encrypt(buf, src)
+	c.c2.Decrypt(buf, buf)
+	c.c3.Encrypt(dst, buf)
+}
+
+func (c *tripleDESCipher) Decrypt(dst, src []byte) {
+	buf := make([]byte, DESBlockSize)
+	c.c3.Decrypt(buf, src)
+	c.c2.Encrypt(buf, buf)
+	c.c1.Decrypt(dst, buf)
+}
+
+// ReducedDESKey spreads the low bits of k over the 7 key bits of each byte, leaving parity bits clear
+func ReducedDESKey(k uint32) []byte {
+	key := make([]byte, 8)
+	for i := range key {
+		key[i] = byte((k>>(7*uint(i)))&0x7f) << 1
+	}
+	return key
+}
+
+// DoubleDESEncrypt encrypts a single block as DES_k2(DES_k1(plainText)) with reduced keys
+func DoubleDESEncrypt(plainText []byte, k1, k2 uint32) []byte {
+	c1, _ := NewDESCipher(ReducedDESKey(k1))
+	c2, _ := NewDESCipher(ReducedDESKey(k2))
+	cipherText := make([]byte, DESBlockSize)
+	c1.Encrypt(cipherText, plainText)
+	c2.Encrypt(cipherText, cipherText)
+	return cipherText
+}
+
+// MeetInTheMiddle recovers (k1, k2) of double DES over a keyBits-wide key space from known plaintext/ciphertext
+// block pairs. It returns the number of single DES operations spent, to compare against 2^(2*keyBits) brute force.
+func MeetInTheMiddle(plainTexts, cipherTexts [][]byte, keyBits uint) (uint32, uint32, int, error) {
+	if len(plainTexts) == 0 || len(plainTexts) != len(cipherTexts) {
+		return 0, 0, 0, errors.New("MeetInTheMiddle needs at least one plaintext/ciphertext pair")
+	}
+	keySpace := uint32(1) << keyBits
+	operations := 0
+
+	middle := make(map[uint64][]uint32, keySpace)
+	buf := make([]byte, DESBlockSize)
+	for k1 := uint32(0); k1 < keySpace; k1++ {
+		c, _ := NewDESCipher(ReducedDESKey(k1))
+		c.Encrypt(buf, plainTexts[0])
+		operations++
+		m := binary.BigEndian.Uint64(buf)
+		middle[m] = append(middle[m], k1)
+	}
+
+	for k2 := uint32(0); k2 < keySpace; k2++ {
+		c, _ := NewDESCipher(ReducedDESKey(k2))
+		c.Decrypt(buf, cipherTexts[0])
+		operations++
+		for _, k1 := range middle[binary.BigEndian.Uint64(buf)] {
+			// confirm the candidate on the remaining pairs to get rid of false positives
+			confirmed := true
+			for i := 1; i < len(plainTexts) && confirmed; i++ {
+				operations += 2
+				confirmed = bytes.Equal(DoubleDESEncrypt(plainTexts[i], k1, k2), cipherTexts[i])
+			}
+			if confirmed {
+				return k1, k2, operations, nil
+			}
+		}
+	}
+	return 0, 0, operations, errors.New("No key pair found")
+}
+
+func mustDecodeHex(s string) []byte {
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return buf
+}
+
+func TestDESKnownAnswer(t *testing.T) {
+	vectors := []struct{ key, plainText, cipherText string }{
+		{"133457799bbcdff1", "0123456789abcdef", "85e813540f0ab405"},
+		{"0123456789abcdef", "4e6f772069732074", "3fa40e8a984d4815"},
+		{"0000000000000000", "0000000000000000", "8ca64de9c1b123a7"},
+	}
+	for _, v := range vectors {
+		c, err := NewDESCipher(mustDecodeHex(v.key))
+		assert.Nil(t, err)
+		buf := make([]byte, DESBlockSize)
+		c.Encrypt(buf, mustDecodeHex(v.plainText))
+		assert.Equal(t, v.cipherText, hex.EncodeToString(buf))
+		c.Decrypt(buf, buf)
+		assert.Equal(t, v.plainText, hex.EncodeToString(buf))
+	}
+}
+
+func TestTripleDESKnownAnswer(t *testing.T) {
+	// SP 800-67 example
+	key := mustDecodeHex("0123456789abcdef" + "23456789abcdef01" + "456789abcdef0123")
+	plainText := []byte("The qufck brown fox jump")
+	expected := "a826fd8ce53b855fcce21c8112256fe668d5c05dd9b6b900"
+
+	c, err := NewTripleDESCipher(key)
+	assert.Nil(t, err)
+	buf := make([]byte, len(plainText))
+	for i := 0; i < len(plainText); i += DESBlockSize {
+		c.Encrypt(buf[i:i+DESBlockSize], plainText[i:i+DESBlockSize])
+	}
+	assert.Equal(t, expected, hex.EncodeToString(buf))
+	decrypted, err := DecryptECB(c, buf, NoPadding)
+	assert.Nil(t, err)
+	assert.Equal(t, plainText, decrypted)
+}
+
+func TestDESMatchesStdlib(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		for _, keySize := range []int{8, 16, 24} {
+			key := GenerateRandomKey(keySize)
+			plainText := GenerateRandomKey(DESBlockSize)
+
+			var ours, theirs cipher.Block
+			if keySize == 8 {
+				ours, _ = NewDESCipher(key)
+				theirs, _ = des.NewCipher(key)
+			} else {
+				ours, _ = NewTripleDESCipher(key)
+				if keySize == 16 {
+					key = append(key, key[:8]...)
+				}
+				theirs, _ = des.NewTripleDESCipher(key)
+			}
+			expected := make([]byte, DESBlockSize)
+			actual := make([]byte, DESBlockSize)
+			theirs.Encrypt(expected, plainText)
+			ours.Encrypt(actual, plainText)
+			assert.Equal(t, expected, actual, strconv.Itoa(keySize))
+		}
+	}
+}
+
+func TestDESKeySize(t *testing.T) {
+	_, err := NewDESCipher(make([]byte, 7))
+	assert.NotNil(t, err)
+	_, err = NewTripleDESCipher(make([]byte, 8))
+	assert.NotNil(t, err)
+}
+
+func TestMeetInTheMiddle(t *testing.T) {
+	// 16 bits per key keeps the test fast; the same code works for 24 bits, it just takes a while
+	const keyBits = 16
+	k1 := uint32(0xbeef)
+	k2 := uint32(0x1337)
+
+	plainTexts := [][]byte{[]byte("attack a"), []byte("t dawn!!")}
+	cipherTexts := make([][]byte, len(plainTexts))
+	for i, p := range plainTexts {
+		cipherTexts[i] = DoubleDESEncrypt(p, k1, k2)
+	}
+
+	foundK1, foundK2, operations, err := MeetInTheMiddle(plainTexts, cipherTexts, keyBits)
+	assert.Nil(t, err)
+	assert.Equal(t, k1, foundK1)
+	assert.Equal(t, k2, foundK2)
+
+	log.Printf("Meet-in-the-middle: %d DES operations vs %d for brute force", operations, uint64(1)<<(2*keyBits))
+	assert.LessOrEqual(t, operations, 2<<keyBits+16)
+}
+
+func TestMeetInTheMiddleNeedsPairs(t *testing.T) {
+	_, _, _, err := MeetInTheMiddle(nil, nil, 16)
+	assert.NotNil(t, err)
+	_, _, _, err = MeetInTheMiddle([][]byte{[]byte("attack a")}, nil, 16)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+Encrypt-then-MAC envelope
+
+Everything in this set breaks because ciphertexts are accepted without being authenticated:
+padding oracles, bit flipping, cut and paste. The envelope puts a MAC over everything the receiver
+is going to act on and checks it before anything is decrypted.
+
+	version (1) || algorithm (1) || IV (16) || ciphertext || HMAC-SHA256 tag (32)
+
+  - the tag covers version, algorithm, IV and ciphertext, so none of them can be swapped
+  - the encryption and MAC keys are derived from one master key with HMAC-SHA256 and a label that
+    includes version and algorithm, so a key is never used for two purposes or two algorithms
+  - tags are compared with hmac.Equal, and every failure returns the same error, so there is no oracle
+
+*/
+
+type EnvelopeAlgorithm byte
+
+const (
+	EnvelopeVersion = 1
+
+	// EnvelopeAES128CBC is AES-128-CBC with PKCS#7 padding and a random IV, then HMAC-SHA256
+	EnvelopeAES128CBC EnvelopeAlgorithm = 1
+	// EnvelopeAES128CTR is AES-128-CTR with a random initial counter block, then HMAC-SHA256
+	EnvelopeAES128CTR EnvelopeAlgorithm = 2
+
+	envelopeHeaderSize = 2
+	envelopeIVSize     = 16
+	envelopeTagSize    = sha256.Size
+	envelopeMasterSize = 32
+)
+
+// ErrEnvelopeOpen is the only error Open returns for a message that is not authentic
+var ErrEnvelopeOpen = errors.New("envelope: message authentication failed")
+
+type Envelope struct {
+	masterKey []byte
+	algorithm EnvelopeAlgorithm
+}
+
+// NewEnvelope seals with algorithm under a 32-byte master key; Open accepts every supported algorithm
+func NewEnvelope(masterKey []byte, algorithm EnvelopeAlgorithm) (*Envelope, error) {
+	if len(masterKey) != envelopeMasterSize {
+		return nil, fmt.Errorf("envelope: master key must be %d bytes", envelopeMasterSize)
+	}
+	if !algorithm.supported() {
+		return nil, fmt.Errorf("envelope: unsupported algorithm %d", algorithm)
+	}
+	return &Envelope{masterKey: append([]byte{}, masterKey...), algorithm: algorithm}, nil
+}
+
+func (algorithm EnvelopeAlgorithm) supported() bool {
+	return algorithm == EnvelopeAES128CBC || algorithm == EnvelopeAES128CTR
+}
+
+// deriveKey is HMAC-SHA256(master key, label), the label naming the version, algorithm and purpose
+func (e *Envelope) deriveKey(algorithm EnvelopeAlgorithm, purpose string) []byte {
+	mac := hmac.New(sha256.New, e.masterKey)
+	fmt.Fprintf(mac, "envelope/v%d/alg%d/%s", EnvelopeVersion, algorithm, purpose)
+	return mac.Sum(nil)
+}
+
+func (e *Envelope) keys(algorithm EnvelopeAlgorithm) ([]byte, []byte) {
+	return e.deriveKey(algorithm, "encryption")[:16], e.deriveKey(algorithm, "authentication")
+}
+
+func (e *Envelope) crypt(algorithm EnvelopeAlgorithm, encKey, IV, input []byte, decrypt bool) ([]byte, error) {
+	block, err := NewAESECBBlock(encKey)
+	if err != nil {
+		return nil, err
+	}
+	switch algorithm {
+	case EnvelopeAES128CBC:
+		if decrypt {
+			return DecryptCBC(block, input, IV, PKCS7Padding)
+		}
+		return EncryptCBC(block, input, IV, PKCS7Padding), nil
+	case EnvelopeAES128CTR:
+		return CTRCrypt(block, IV, CounterBE128, input), nil
+	}
+	return nil, fmt.Errorf("envelope: unsupported algorithm %d", algorithm)
+}
+
+// Seal encrypts plainText with a fresh random IV
+func (e *Envelope) Seal(plainText []byte) []byte {
+	return e.sealWithIV(GenerateRandomKey(envelopeIVSize), plainText)
+}
+
+func (e *Envelope) sealWithIV(IV, plainText []byte) []byte {
+	encKey, macKey := e.keys(e.algorithm)
+	cipherText, err := e.crypt(e.algorithm, encKey, IV, plainText, false)
+	if err != nil {
+		panic(err)
+	}
+
+	sealed := append([]byte{EnvelopeVersion, byte(e.algorithm)}, IV...)
+	sealed = append(sealed, cipherText...)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(sealed)
+	return mac.Sum(sealed)
+}
+
+// Open checks the tag before touching the ciphertext and returns ErrEnvelopeOpen for anything not authentic
+func (e *Envelope) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < envelopeHeaderSize+envelopeIVSize+envelopeTagSize || sealed[0] != EnvelopeVersion {
+		return nil, ErrEnvelopeOpen
+	}
+	algorithm := EnvelopeAlgorithm(sealed[1])
+	if !algorithm.supported() {
+		return nil, ErrEnvelopeOpen
+	}
+	encKey, macKey := e.keys(algorithm)
+
+	body := sealed[:len(sealed)-envelopeTagSize]
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), sealed[len(body):]) {
+		return nil, ErrEnvelopeOpen
+	}
+
+	IV := body[envelopeHeaderSize : envelopeHeaderSize+envelopeIVSize]
+	plainText, err := e.crypt(algorithm, encKey, IV, body[envelopeHeaderSize+envelopeIVSize:], true)
+	if err != nil {
+		return nil, ErrEnvelopeOpen
+	}
+	return plainText, nil
+}
+
+// referenceSeal builds an envelope from the layout above with the standard library alone:
+// crypto/aes and crypto/cipher for the encryption, crypto/hmac for key derivation and tag
+func referenceSeal(masterKey []byte, algorithm EnvelopeAlgorithm, IV, plainText []byte) []byte {
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, masterKey)
+		mac.Write([]byte(fmt.Sprintf("envelope/v1/alg%d/%s", algorithm, purpose)))
+		return mac.Sum(nil)
+	}
+	block, err := aes.NewCipher(derive("encryption")[:16])
+	if err != nil {
+		panic(err)
+	}
+
+	var cipherText []byte
+	switch algorithm {
+	case EnvelopeAES128CBC:
+		padding := aes.BlockSize - len(plainText)%aes.BlockSize
+		padded := append(append([]byte{}, plainText...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+		cipherText = make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, IV).CryptBlocks(cipherText, padded)
+	case EnvelopeAES128CTR:
+		cipherText = make([]byte, len(plainText))
+		cipher.NewCTR(block, IV).XORKeyStream(cipherText, plainText)
+	}
+
+	sealed := append([]byte{1, byte(algorithm)}, IV...)
+	sealed = append(sealed, cipherText...)
+	mac := hmac.New(sha256.New, derive("authentication"))
+	mac.Write(sealed)
+	return mac.Sum(sealed)
+}
+
+func TestEnvelopeVectors(t *testing.T) {
+	masterKey := mustDecodeHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	IV := mustDecodeHex("f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	plainText := []byte("Cooking MC's like a pound of bacon")
+
+	vectors := []struct {
+		algorithm EnvelopeAlgorithm
+		sealed    string
+	}{
+		{EnvelopeAES128CBC, "0101" + "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff" +
+			"a37c284c2056d07472de4f36b5b0ca9ac0a684aba15398a65132c8aaa607b19dc532593cbb6615b9eac0ff5f91f2f8cd" +
+			"1d22dd872ef93849e0fe7c19c336a09beb56343acb795bde207771e8152dfd52"},
+		{EnvelopeAES128CTR, "0102" + "f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff" +
+			"c2d7286c41d488e8d0c18273e664eefc46125b5477de0199b9828fb95c22b6a3635b" +
+			"83d8ea79b5295d7e60806fae7954a22118e78854449370dfbb28ba879be01282"},
+	}
+	for _, v := range vectors {
+		// the vectors come from the reference, not from the code under test
+		assert.Equal(t, v.sealed, hex.EncodeToString(referenceSeal(masterKey, v.algorithm, IV, plainText)))
+
+		envelope, err := NewEnvelope(masterKey, v.algorithm)
+		assert.Nil(t, err)
+		sealed := envelope.sealWithIV(IV, plainText)
+		assert.Equal(t, v.sealed, hex.EncodeToString(sealed))
+
+		opened, err := envelope.Open(mustDecodeHex(v.sealed))
+		assert.Nil(t, err)
+		assert.Equal(t, plainText, opened)
+	}
+}
+
+func TestEnvelopeMatchesReference(t *testing.T) {
+	masterKey := GenerateRandomKey(32)
+	for _, algorithm := range []EnvelopeAlgorithm{EnvelopeAES128CBC, EnvelopeAES128CTR} {
+		envelope, _ := NewEnvelope(masterKey, algorithm)
+		for _, size := range []int{0, 15, 16, 33} {
+			IV := GenerateRandomKey(16)
+			plainText := GenerateRandomKey(size)
+			assert.Equal(t, referenceSeal(masterKey, algorithm, IV, plainText), envelope.sealWithIV(IV, plainText))
+		}
+	}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	masterKey := GenerateRandomKey(32)
+	for _, algorithm := range []EnvelopeAlgorithm{EnvelopeAES128CBC, EnvelopeAES128CTR} {
+		envelope, err := NewEnvelope(masterKey, algorithm)
+		assert.Nil(t, err)
+		for _, size := range []int{0, 1, 16, 17, 100} {
+			plainText := GenerateRandomKey(size)
+			sealed := envelope.Seal(plainText)
+			assert.NotEqual(t, sealed, envelope.Seal(plainText))
+
+			opened, err := envelope.Open(sealed)
+			assert.Nil(t, err)
+			assert.Equal(t, plainText, opened)
+		}
+	}
+}
+
+func TestEnvelopeRejectsTampering(t *testing.T) {
+	masterKey := GenerateRandomKey(32)
+	cbc, _ := NewEnvelope(masterKey, EnvelopeAES128CBC)
+	ctr, _ := NewEnvelope(masterKey, EnvelopeAES128CTR)
+	sealed := cbc.Seal([]byte("email=foo@bar.com&uid=10&role=user"))
+
+	// every byte is covered: header, IV, ciphertext and tag
+	for i := range sealed {
+		tampered := append([]byte{}, sealed...)
+		tampered[i] ^= 0x01
+		_, err := cbc.Open(tampered)
+		assert.Equal(t, ErrEnvelopeOpen, err, "byte %d", i)
+	}
+
+	// the algorithm byte is authenticated and part of the key derivation, so relabeling fails
+	relabeled := append([]byte{}, sealed...)
+	relabeled[1] = byte(EnvelopeAES128CTR)
+	_, err := ctr.Open(relabeled)
+	assert.Equal(t, ErrEnvelopeOpen, err)
+
+	// another master key
+	other, _ := NewEnvelope(GenerateRandomKey(32), EnvelopeAES128CBC)
+	_, err = other.Open(sealed)
+	assert.Equal(t, ErrEnvelopeOpen, err)
+
+	for _, short := range [][]byte{nil, sealed[:1], sealed[:envelopeHeaderSize+envelopeIVSize+envelopeTagSize-1]} {
+		_, err = cbc.Open(short)
+		assert.Equal(t, ErrEnvelopeOpen, err)
+	}
+
+	_, err = NewEnvelope(GenerateRandomKey(16), EnvelopeAES128CBC)
+	assert.NotNil(t, err)
+	_, err = NewEnvelope(masterKey, 3)
+	assert.NotNil(t, err)
+}
+
+func TestEnvelopeKeySeparation(t *testing.T) {
+	envelope, _ := NewEnvelope(GenerateRandomKey(32), EnvelopeAES128CBC)
+	cbcEnc, cbcMac := envelope.keys(EnvelopeAES128CBC)
+	ctrEnc, ctrMac := envelope.keys(EnvelopeAES128CTR)
+	keys := [][]byte{cbcEnc, cbcMac[:16], ctrEnc, ctrMac[:16]}
+	for i := range keys {
+		for j := i + 1; j < len(keys); j++ {
+			assert.NotEqual(t, keys[i], keys[j])
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+GCM nonce reuse: the forbidden attack
+
+For one message, with blocks B_1..B_n from GHASHBlocks, the tag is
+
+	t = B_1*H^n + B_2*H^(n-1) + ... + B_n*H + E(K, J0)
+
+so t is the value of a polynomial in H. Two messages under the same key and nonce share E(K, J0),
+and adding their two polynomials cancels it:
+
+	f(H) = sum of both sets of B_i*H^k + t_1 + t_2 = 0
+
+H is a root of f. f is built from public values only, so factoring it over GF(2^128) gives a short list
+of candidates for the authentication key, and any third message narrows it down further.
+
+Finding roots:
+  - gcd(f, x^(2^128) - x) keeps exactly the product of the distinct linear factors of f
+  - Cantor-Zassenhaus equal degree factorization splits that product: in characteristic 2 the trace
+    Tr(a) = a + a^2 + a^4 + ... + a^(2^127) of a random polynomial a is 0 or 1 at every root,
+    so gcd(g, Tr(a)) separates the roots into two random halves
+
+With H and E(K, J0) = t_1 + GHASH(H, A_1, C_1) known, the attacker computes valid tags for any
+ciphertext under that nonce; known plaintext gives the keystream to choose the ciphertext as well.
+
+*/
+
+// GFOne is the multiplicative identity, x^0 in GCM bit order
+var GFOne = GFElement{Hi: 1 << 63}
+
+// Inverse computes x^(2^128 - 2), the multiplicative inverse of a non-zero x
+func (x GFElement) Inverse() GFElement {
+	if x == (GFElement{}) {
+		panic("GFElement: zero has no inverse")
+	}
+	// 2^128 - 2 is 127 ones followed by a zero bit
+	result := GFOne
+	for i := 0; i < 128; i++ {
+		result = result.Mul(result)
+		if i < 127 {
+			result = result.Mul(x)
+		}
+	}
+	return result
+}
+
+// GFPoly is a polynomial over GF(2^128), coefficient i belonging to x^i
+type GFPoly []GFElement
+
+func (p GFPoly) trim() GFPoly {
+	for len(p) > 0 && p[len(p)-1] == (GFElement{}) {
+		p = p[:len(p)-1]
+	}
+	return p
+}
+
+// Degree is -1 for the zero polynomial
+func (p GFPoly) Degree() int {
+	return len(p.trim()) - 1
+}
+
+func (p GFPoly) Add(q GFPoly) GFPoly {
+	if len(p) < len(q) {
+		p, q = q, p
+	}
+	sum := append(GFPoly{}, p...)
+	for i := range q {
+		sum[i] = sum[i].Add(q[i])
+	}
+	return sum.trim()
+}
+
+func (p GFPoly) Mul(q GFPoly) GFPoly {
+	p, q = p.trim(), q.trim()
+	if len(p) == 0 || len(q) == 0 {
+		return GFPoly{}
+	}
+	product := make(GFPoly, len(p)+len(q)-1)
+	for i := range p {
+		for j := range q {
+			product[i+j] = product[i+j].Add(p[i].Mul(q[j]))
+		}
+	}
+	return product.trim()
+}
+
+func (p GFPoly) DivMod(q GFPoly) (GFPoly, GFPoly) {
+	q = q.trim()
+	if len(q) == 0 {
+		panic("GFPoly: division by zero")
+	}
+	remainder := append(GFPoly{}, p.trim()...)
+	if len(remainder) < len(q) {
+		return GFPoly{}, remainder
+	}
+	quotient := make(GFPoly, len(remainder)-len(q)+1)
+	leadInverse := q[len(q)-1].Inverse()
+	for len(remainder) >= len(q) {
+		shift := len(remainder) - len(q)
+		factor := remainder[len(remainder)-1].Mul(leadInverse)
+		quotient[shift] = factor
+		for i := range q {
+			remainder[shift+i] = remainder[shift+i].Add(factor.Mul(q[i]))
+		}
+		remainder = remainder.trim()
+	}
+	return quotient.trim(), remainder
+}
+
+func (p GFPoly) Mod(q GFPoly) GFPoly {
+	_, remainder := p.DivMod(q)
+	return remainder
+}
+
+// Monic divides p by its leading coefficient
+func (p GFPoly) Monic() GFPoly {
+	p = p.trim()
+	if len(p) == 0 {
+		return p
+	}
+	inverse := p[len(p)-1].Inverse()
+	monic := make(GFPoly, len(p))
+	for i := range p {
+		monic[i] = p[i].Mul(inverse)
+	}
+	return monic
+}
+
+// Eval computes p(x) with Horner's rule
+func (p GFPoly) Eval(x GFElement) GFElement {
+	var result GFElement
+	for i := len(p) - 1; i >= 0; i-- {
+		result = result.Mul(x).Add(p[i])
+	}
+	return result
+}
+
+// GFPolyGCD returns the monic greatest common divisor of a and b
+func GFPolyGCD(a, b GFPoly) GFPoly {
+	a, b = a.trim(), b.trim()
+	for len(b) > 0 {
+		a, b = b, a.Mod(b)
+	}
+	return a.Monic()
+}
+
+// GFPolyRoots returns the distinct roots of f in GF(2^128)
+func GFPolyRoots(f GFPoly) []GFElement {
+	f = f.Monic()
+	if f.Degree() < 1 {
+		return nil
+	}
+	// x^(2^128) mod f by squaring x 128 times
+	x := GFPoly{GFElement{}, GFOne}
+	power := x.Mod(f)
+	for i := 0; i < 128; i++ {
+		power = power.Mul(power).Mod(f)
+	}
+	return splitLinearFactors(GFPolyGCD(f, power.Add(x)))
+}
+
+// splitLinearFactors finds the roots of a monic product of distinct linear factors (Cantor-Zassenhaus)
+func splitLinearFactors(g GFPoly) []GFElement {
+	switch g.Degree() {
+	case -1, 0:
+		return nil
+	case 1:
+		// x + c has the root c
+		return []GFElement{g[0]}
+	}
+
+	for {
+		a := make(GFPoly, g.Degree())
+		for i := range a {
+			a[i] = GFElementFromBytes(GenerateRandomKey(GCMBlockSize))
+		}
+		a = a.trim()
+		trace := a
+		square := a
+		for i := 1; i < 128; i++ {
+			square = square.Mul(square).Mod(g)
+			trace = trace.Add(square)
+		}
+		d := GFPolyGCD(g, trace)
+		if d.Degree() > 0 && d.Degree() < g.Degree() {
+			rest, _ := g.DivMod(d)
+			return append(splitLinearFactors(d), splitLinearFactors(rest.Monic())...)
+		}
+	}
+}
+
+// GCMMessage is what an observer of a GCM protected exchange sees
+type GCMMessage struct {
+	Nonce, AAD, CipherText, Tag []byte
+}
+
+// tagPolynomial is the polynomial in H whose value is zero for the right H: GHASH(H) + tag, less E(K, J0)
+func tagPolynomial(m GCMMessage) GFPoly {
+	blocks := GHASHBlocks(m.AAD, m.CipherText)
+	p := make(GFPoly, len(blocks)+1)
+	p[0] = GFElementFromBytes(m.Tag)
+	for i, block := range blocks {
+		p[len(blocks)-i] = block
+	}
+	return p
+}
+
+// RecoverGCMAuthKeys returns the candidates for H given messages that were all sealed under the same nonce.
+// The first two messages give the polynomial, the others filter the candidates.
+func RecoverGCMAuthKeys(messages []GCMMessage) ([]GFElement, error) {
+	if len(messages) < 2 {
+		return nil, errors.New("Need at least two messages with the same nonce")
+	}
+	for _, m := range messages {
+		if string(m.Nonce) != string(messages[0].Nonce) {
+			return nil, errors.New("Messages do not share a nonce")
+		}
+		if len(m.Tag) != GCMBlockSize {
+			return nil, errors.New("Attack needs full 16-byte tags")
+		}
+	}
+
+	f := tagPolynomial(messages[0]).Add(tagPolynomial(messages[1]))
+	candidates := make([]GFElement, 0)
+	for _, H := range GFPolyRoots(f) {
+		mask := TagMaskFor(H, messages[0])
+		consistent := true
+		for _, m := range messages[2:] {
+			consistent = consistent && TagMaskFor(H, m) == mask
+		}
+		if consistent {
+			candidates = append(candidates, H)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("No candidate authentication key, were the nonces really reused?")
+	}
+	return candidates, nil
+}
+
+// TagMaskFor derives E(K, J0) from one message given a guess of H
+func TagMaskFor(H GFElement, m GCMMessage) GFElement {
+	return GFElementFromBytes(m.Tag).Add(GHASH(H, m.AAD, m.CipherText))
+}
+
+// ForgeGCMTag computes the tag for aad and cipherText under the nonce of known
+func ForgeGCMTag(H GFElement, known GCMMessage, aad, cipherText []byte) []byte {
+	return GHASH(H, aad, cipherText).Add(TagMaskFor(H, known)).Bytes()
+}
+
+// GCMNonceReuseServer is a local GCM endpoint with the bug under attack: it seals everything under one nonce
+type GCMNonceReuseServer struct {
+	gcm   *GCM
+	nonce []byte
+}
+
+func NewGCMNonceReuseServer() *GCMNonceReuseServer {
+	block, err := NewAESECBBlock(GenerateRandomAESKey())
+	if err != nil {
+		log.Fatal(err)
+	}
+	gcm, err := NewGCM(block, GCMBlockSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return &GCMNonceReuseServer{gcm: gcm, nonce: GenerateRandomKey(GCMStandardNonce)}
+}
+
+func (s *GCMNonceReuseServer) Encrypt(plainText, aad []byte) GCMMessage {
+	sealed := s.gcm.Seal(nil, s.nonce, plainText, aad)
+	split := len(sealed) - GCMBlockSize
+	return GCMMessage{Nonce: s.nonce, AAD: aad, CipherText: sealed[:split], Tag: sealed[split:]}
+}
+
+// Decrypt accepts a message only if its tag verifies
+func (s *GCMNonceReuseServer) Decrypt(m GCMMessage) ([]byte, error) {
+	return s.gcm.Open(nil, m.Nonce, append(append([]byte{}, m.CipherText...), m.Tag...), m.AAD)
+}
+
+func TestGFInverse(t *testing.T) {
+	for i := 0; i < 4; i++ {
+		x := GFElementFromBytes(GenerateRandomKey(GCMBlockSize))
+		assert.Equal(t, GFOne, x.Mul(x.Inverse()))
+	}
+	assert.Equal(t, GFOne, GFOne.Inverse())
+	assert.Panics(t, func() { GFElement{}.Inverse() })
+}
+
+func TestGFPolyArithmetic(t *testing.T) {
+	a := GFPoly{GFElementFromBytes(GenerateRandomKey(16)), GFElementFromBytes(GenerateRandomKey(16)), GFOne}
+	b := GFPoly{GFElementFromBytes(GenerateRandomKey(16)), GFOne}
+	c := GFPoly{GFElementFromBytes(GenerateRandomKey(16))}
+
+	quotient, remainder := a.Mul(b).Add(c).DivMod(b)
+	assert.Equal(t, a, quotient)
+	assert.Equal(t, c, remainder)
+	assert.Equal(t, 3, a.Mul(b).Degree())
+	assert.Equal(t, -1, a.Add(a).Degree())
+	// b is monic, and random a and b + c share no factor
+	assert.Equal(t, b, GFPolyGCD(a.Mul(b), b.Add(c).Mul(b)))
+
+	x := GFElementFromBytes(GenerateRandomKey(16))
+	assert.Equal(t, a.Eval(x).Mul(b.Eval(x)), a.Mul(b).Eval(x))
+}
+
+func TestGFPolyRoots(t *testing.T) {
+	roots := make([]GFElement, 5)
+	f := GFPoly{GFOne}
+	for i := range roots {
+		roots[i] = GFElementFromBytes(GenerateRandomKey(16))
+		f = f.Mul(GFPoly{roots[i], GFOne})
+	}
+	// a repeated root and an irreducible quadratic factor must not confuse the root finder
+	f = f.Mul(GFPoly{roots[0], GFOne})
+	f = f.Mul(GFPoly{GFOne, GFOne, GFOne}.Mul(GFPoly{GFElementFromBytes(GenerateRandomKey(16))}))
+
+	found := GFPolyRoots(f)
+	for _, root := range roots {
+		assert.Contains(t, found, root)
+		assert.Equal(t, GFElement{}, f.Eval(root))
+	}
+	for _, root := range found {
+		assert.Equal(t, GFElement{}, f.Eval(root))
+	}
+}
+
+func TestGCMForbiddenAttack(t *testing.T) {
+	server := NewGCMNonceReuseServer()
+	knownPlainText := []byte("amount=100&to=alice&memo=rent for october")
+	known := server.Encrypt(knownPlainText, []byte("session=1"))
+	other := server.Encrypt([]byte("amount=5&to=bob"), []byte("session=2"))
+
+	candidates, err := RecoverGCMAuthKeys([]GCMMessage{known, other})
+	assert.Nil(t, err)
+	assert.Contains(t, candidates, server.gcm.H)
+	log.Printf("%d candidates for H", len(candidates))
+
+	// known plaintext gives the keystream for the reused nonce, H gives the tag
+	forgedPlainText := []byte("amount=999999&to=mallory")
+	keyStream := XorStr(knownPlainText, known.CipherText)
+	forgedCipherText := XorStr(forgedPlainText, keyStream[:len(forgedPlainText)])
+	forgedAAD := []byte("session=1337")
+
+	accepted := 0
+	for _, H := range candidates {
+		forged := GCMMessage{Nonce: known.Nonce, AAD: forgedAAD, CipherText: forgedCipherText,
+			Tag: ForgeGCMTag(H, known, forgedAAD, forgedCipherText)}
+		plainText, err := server.Decrypt(forged)
+		if err == nil {
+			accepted++
+			assert.Equal(t, forgedPlainText, plainText)
+		}
+	}
+	assert.Equal(t, 1, accepted)
+}
+
+func TestGCMForbiddenAttackThirdMessageFilters(t *testing.T) {
+	server := NewGCMNonceReuseServer()
+	messages := []GCMMessage{
+		server.Encrypt([]byte("Cooking MC's like a pound of bacon"), nil),
+		server.Encrypt([]byte("Burning 'em, if you ain't quick and nimble"), []byte("header")),
+		server.Encrypt([]byte("I go crazy when I hear a cymbal"), []byte("another header")),
+	}
+	candidates, err := RecoverGCMAuthKeys(messages)
+	assert.Nil(t, err)
+	assert.Equal(t, []GFElement{server.gcm.H}, candidates)
+
+	_, err = RecoverGCMAuthKeys(messages[:1])
+	assert.NotNil(t, err)
+	messages[2].Nonce = GenerateRandomKey(GCMStandardNonce)
+	_, err = RecoverGCMAuthKeys(messages)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+Galois/Counter Mode
+
+GCM is CTR mode for confidentiality plus GHASH, a polynomial MAC over GF(2^128), for integrity.
+
+The hash key is H = E(K, 0^128). GHASH splits the additional data and the ciphertext into 16-byte blocks
+(each zero-padded), appends a block holding both lengths in bits, and evaluates
+
+	X_i = (X_{i-1} + B_i) * H
+
+so the tag is a polynomial in H whose coefficients are the message blocks, masked with E(K, J0).
+J0 is the nonce followed by a 32-bit counter set to 1 for 96-bit nonces, or GHASH of the nonce otherwise.
+The keystream starts at inc32(J0): only the last 32 bits of the counter block count up.
+
+GF(2^128) uses the GCM bit order: bit 0 is the most significant bit of byte 0, and is the coefficient of x^0.
+The field polynomial is x^128 + x^7 + x^2 + x + 1.
+
+*/
+
+const (
+	GCMBlockSize      = 16
+	GCMStandardNonce  = 12
+	GCMMinimumTagSize = 4
+)
+
+// GFElement is an element of GF(2^128) in GCM bit order, Hi holding bytes 0-7 and Lo bytes 8-15 big-endian
+type GFElement struct {
+	Hi, Lo uint64
+}
+
+func GFElementFromBytes(b []byte) GFElement {
+	if len(b) != GCMBlockSize {
+		panic("GFElementFromBytes: need exactly 16 bytes")
+	}
+	return GFElement{binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])}
+}
+
+func (x GFElement) Bytes() []byte {
+	b := make([]byte, GCMBlockSize)
+	binary.BigEndian.PutUint64(b[:8], x.Hi)
+	binary.BigEndian.PutUint64(b[8:], x.Lo)
+	return b
+}
+
+func (x GFElement) String() string {
+	return hex.EncodeToString(x.Bytes())
+}
+
+// Add is addition (and subtraction) in GF(2^128)
+func (x GFElement) Add(y GFElement) GFElement {
+	return GFElement{x.Hi ^ y.Hi, x.Lo ^ y.Lo}
+}
+
+// Mul is the GCM multiplication, algorithm 1 of SP 800-38D
+func (x GFElement) Mul(y GFElement) GFElement {
+	var z GFElement
+	v := y
+	for i := 0; i < 128; i++ {
+		var bit uint64
+		if i < 64 {
+			bit = x.Hi >> uint(63-i) & 1
+		} else {
+			bit = x.Lo >> uint(127-i) & 1
+		}
+		if bit == 1 {
+			z = z.Add(v)
+		}
+		// multiply v by x: a right shift in GCM bit order, reduced by R = 11100001 || 0^120
+		carry := v.Lo & 1
+		v.Lo = v.Lo>>1 | v.Hi<<63
+		v.Hi >>= 1
+		if carry == 1 {
+			v.Hi ^= 0xe100000000000000
+		}
+	}
+	return z
+}
+
+// GHASHBlocks turns aad and cipherText into the padded blocks GHASH evaluates, the length block last
+func GHASHBlocks(aad, cipherText []byte) []GFElement {
+	blocks := make([]GFElement, 0, (len(aad)+GCMBlockSize-1)/GCMBlockSize+(len(cipherText)+GCMBlockSize-1)/GCMBlockSize+1)
+	for _, data := range [][]byte{aad, cipherText} {
+		for i := 0; i < len(data); i += GCMBlockSize {
+			block := make([]byte, GCMBlockSize)
+			copy(block, data[i:])
+			blocks = append(blocks, GFElementFromBytes(block))
+		}
+	}
+	return append(blocks, GFElement{uint64(len(aad)) * 8, uint64(len(cipherText)) * 8})
+}
+
+// GHASH is the GCM universal hash of aad and cipherText under the hash key H
+func GHASH(H GFElement, aad, cipherText []byte) GFElement {
+	var x GFElement
+	for _, block := range GHASHBlocks(aad, cipherText) {
+		x = x.Add(block).Mul(H)
+	}
+	return x
+}
+
+// GCM is Galois/Counter Mode on top of any 128-bit block cipher. It implements cipher.AEAD.
+type GCM struct {
+	block   cipher.Block
+	H       GFElement
+	tagSize int
+}
+
+// NewGCM creates a GCM with tags truncated to tagSize bytes (16 for the full tag)
+func NewGCM(block cipher.Block, tagSize int) (*GCM, error) {
+	if block.BlockSize() != GCMBlockSize {
+		return nil, errors.New("GCM requires a 128-bit block cipher")
+	}
+	if tagSize < GCMMinimumTagSize || tagSize > GCMBlockSize {
+		return nil, errors.New("GCM tag size must be between 4 and 16 bytes")
+	}
+	H := make([]byte, GCMBlockSize)
+	block.Encrypt(H, H)
+	return &GCM{block: block, H: GFElementFromBytes(H), tagSize: tagSize}, nil
+}
+
+func (g *GCM) NonceSize() int {
+	return GCMStandardNonce
+}
+
+func (g *GCM) Overhead() int {
+	return g.tagSize
+}
+
+// counterBlock computes J0 from the nonce
+func (g *GCM) counterBlock(nonce []byte) []byte {
+	if len(nonce) == GCMStandardNonce {
+		J0 := make([]byte, GCMBlockSize)
+		copy(J0, nonce)
+		J0[GCMBlockSize-1] = 1
+		return J0
+	}
+	return GHASH(g.H, nil, nonce).Bytes()
+}
+
+// gctr XORs src with the keystream E(J0+1), E(J0+2), ... where only the last 32 bits are incremented
+func (g *GCM) gctr(dst, src, J0 []byte) {
+	counter := append([]byte{}, J0...)
+	keyStream := make([]byte, GCMBlockSize)
+	for i := 0; i < len(src); i += GCMBlockSize {
+		count := binary.BigEndian.Uint32(counter[12:])
+		binary.BigEndian.PutUint32(counter[12:], count+1)
+		g.block.Encrypt(keyStream, counter)
+		for j := i; j < len(src) && j < i+GCMBlockSize; j++ {
+			dst[j] = src[j] ^ keyStream[j-i]
+		}
+	}
+}
+
+// TagMask returns E(K, J0), the value GHASH is masked with to form the tag
+func (g *GCM) TagMask(nonce []byte) GFElement {
+	mask := make([]byte, GCMBlockSize)
+	g.block.Encrypt(mask, g.counterBlock(nonce))
+	return GFElementFromBytes(mask)
+}
+
+// tag computes the full 16-byte tag; callers truncate it
+func (g *GCM) tag(nonce, aad, cipherText []byte) []byte {
+	return GHASH(g.H, aad, cipherText).Add(g.TagMask(nonce)).Bytes()
+}
+
+// Seal appends the encrypted plainText followed by the tag to dst
+func (g *GCM) Seal(dst, nonce, plainText, aad []byte) []byte {
+	if len(nonce) == 0 {
+		panic("GCM: nonce must not be empty")
+	}
+	out := make([]byte, len(plainText), len(plainText)+g.tagSize)
+	g.gctr(out, plainText, g.counterBlock(nonce))
+	out = append(out, g.tag(nonce, aad, out)[:g.tagSize]...)
+	return append(dst, out...)
+}
+
+// Open authenticates and decrypts sealed (ciphertext followed by tag), appending the plaintext to dst
+func (g *GCM) Open(dst, nonce, sealed, aad []byte) ([]byte, error) {
+	if len(nonce) == 0 {
+		return nil, errors.New("GCM: nonce must not be empty")
+	}
+	if len(sealed) < g.tagSize {
+		return nil, errors.New("GCM: message too short")
+	}
+	cipherText := sealed[:len(sealed)-g.tagSize]
+	tag := sealed[len(sealed)-g.tagSize:]
+	if subtle.ConstantTimeCompare(tag, g.tag(nonce, aad, cipherText)[:g.tagSize]) != 1 {
+		return nil, errors.New("GCM: message authentication failed")
+	}
+	plainText := make([]byte, len(cipherText))
+	g.gctr(plainText, cipherText, g.counterBlock(nonce))
+	return append(dst, plainText...), nil
+}
+
+var _ cipher.AEAD = &GCM{}
+
+func TestGFMul(t *testing.T) {
+	one := GFElement{Hi: 1 << 63}
+	x := GFElementFromBytes(mustDecodeHex("66e94bd4ef8a2c3b884cfa59ca342b2e"))
+	y := GFElementFromBytes(mustDecodeHex("0388dace60b6a392f328c2b971b2fe78"))
+
+	assert.Equal(t, x, x.Mul(one))
+	assert.Equal(t, x.Mul(y), y.Mul(x))
+	assert.Equal(t, GFElement{}, x.Mul(GFElement{}))
+	// x^127 * x = x^128 = x^7 + x^2 + x + 1
+	assert.Equal(t, GFElement{Hi: 0xe100000000000000}, GFElement{Lo: 1}.Mul(GFElement{Hi: 1 << 62}))
+	// distributive
+	z := GFElementFromBytes(mustDecodeHex("feedfacedeadbeeffeedfacedeadbeef"))
+	assert.Equal(t, x.Mul(y.Add(z)), x.Mul(y).Add(x.Mul(z)))
+}
+
+func TestGHASH(t *testing.T) {
+	// GCM spec test case 2: H = E(0, 0), GHASH(H, {}, C) = f38cbb1ad69223dcc3457ae5b6b0f885
+	H := GFElementFromBytes(mustDecodeHex("66e94bd4ef8a2c3b884cfa59ca342b2e"))
+	cipherText := mustDecodeHex("0388dace60b6a392f328c2b971b2fe78")
+	assert.Equal(t, "f38cbb1ad69223dcc3457ae5b6b0f885", GHASH(H, nil, cipherText).String())
+
+	blocks := GHASHBlocks([]byte("abc"), cipherText)
+	assert.Equal(t, 3, len(blocks))
+	assert.Equal(t, GFElement{Hi: 24, Lo: 128}, blocks[2])
+}
+
+const (
+	gcmSpecKey = "feffe9928665731c6d6a8f9467308308"
+	gcmSpecPT  = "d9313225f88406e5a55909c5aff5269a86a7a9531534f7da2e4c303d8a318a72" +
+		"1c3c0c95956809532fcf0e2449a6b525b16aedf5aa0de657ba637b391aafd255"
+	gcmSpecAAD = "feedfacedeadbeeffeedfacedeadbeefabaddad2"
+)
+
+func TestGCMSpecVectors(t *testing.T) {
+	vectors := []struct {
+		name, key, nonce, plainText, aad, cipherText, tag string
+	}{
+		{"Test Case 1", "00000000000000000000000000000000", "000000000000000000000000", "", "",
+			"", "58e2fccefa7e3061367f1d57a4e7455a"},
+		{"Test Case 2", "00000000000000000000000000000000", "000000000000000000000000", "00000000000000000000000000000000", "",
+			"0388dace60b6a392f328c2b971b2fe78", "ab6e47d42cec13bdf53a67b21257bddf"},
+		{"Test Case 3", gcmSpecKey, "cafebabefacedbaddecaf888", gcmSpecPT, "",
+			"42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e" +
+				"21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091473f5985",
+			"4d5c2af327cd64a62cf35abd2ba6fab4"},
+		{"Test Case 4", gcmSpecKey, "cafebabefacedbaddecaf888", gcmSpecPT[:120], gcmSpecAAD,
+			"42831ec2217774244b7221b784d0d49ce3aa212f2c02a4e035c17e2329aca12e" +
+				"21d514b25466931c7d8f6a5aac84aa051ba30b396a0aac973d58e091",
+			"5bc94fbc3221a5db94fae95ae7121a47"},
+		{"Test Case 5", gcmSpecKey, "cafebabefacedbad", gcmSpecPT[:120], gcmSpecAAD,
+			"61353b4c2806934a777ff51fa22a4755699b2a714fcdc6f83766e5f97b6c7423" +
+				"73806900e49f24b22b097544d4896b424989b5e1ebac0f07c23f4598",
+			"3612d2e79e3b0785561be14aaca2fccb"},
+		{"Test Case 6", gcmSpecKey,
+			"9313225df88406e555909c5aff5269aa6a7a9538534f7da1e4c303d2a318a728" +
+				"c3c0c95156809539fcf0e2429a6b525416aedbf5a0de6a57a637b39b",
+			gcmSpecPT[:120], gcmSpecAAD,
+			"8ce24998625615b603a033aca13fb894be9112a5c3a211a8ba262a3cca7e2ca7" +
+				"01e4a9a4fba43c90ccdcb281d48c7c6fd62875d2aca417034c34aee5",
+			"619cc5aefffe0bfa462af43c1699d050"},
+	}
+	for _, v := range vectors {
+		block, err := NewAESECBBlock(mustDecodeHex(v.key))
+		assert.Nil(t, err)
+		gcm, err := NewGCM(block, 16)
+		assert.Nil(t, err)
+
+		nonce, aad := mustDecodeHex(v.nonce), mustDecodeHex(v.aad)
+		sealed := gcm.Seal(nil, nonce, mustDecodeHex(v.plainText), aad)
+		assert.Equal(t, v.cipherText+v.tag, hex.EncodeToString(sealed), v.name)
+
+		opened, err := gcm.Open(nil, nonce, sealed, aad)
+		assert.Nil(t, err, v.name)
+		assert.Equal(t, v.plainText, hex.EncodeToString(opened), v.name)
+	}
+}
+
+func TestGCMTagTruncationAndTampering(t *testing.T) {
+	block, _ := NewAESECBBlock(mustDecodeHex(gcmSpecKey))
+	full, _ := NewGCM(block, 16)
+	short, _ := NewGCM(block, 12)
+	nonce := mustDecodeHex("cafebabefacedbaddecaf888")
+	plainText, aad := mustDecodeHex(gcmSpecPT[:120]), mustDecodeHex(gcmSpecAAD)
+
+	fullSealed := full.Seal(nil, nonce, plainText, aad)
+	shortSealed := short.Seal(nil, nonce, plainText, aad)
+	assert.Equal(t, fullSealed[:len(fullSealed)-4], shortSealed)
+
+	opened, err := short.Open(nil, nonce, shortSealed, aad)
+	assert.Nil(t, err)
+	assert.Equal(t, plainText, opened)
+
+	for _, i := range []int{0, len(plainText) - 1, len(shortSealed) - 1} {
+		tampered := append([]byte{}, shortSealed...)
+		tampered[i] ^= 1
+		_, err = short.Open(nil, nonce, tampered, aad)
+		assert.NotNil(t, err)
+	}
+	_, err = short.Open(nil, nonce, shortSealed, aad[1:])
+	assert.NotNil(t, err)
+	_, err = short.Open(nil, nonce, shortSealed[:11], aad)
+	assert.NotNil(t, err)
+
+	_, err = NewGCM(block, 3)
+	assert.NotNil(t, err)
+	desBlock, _ := NewDESCipher([]byte("SUBMARIN"))
+	_, err = NewGCM(desBlock, 16)
+	assert.NotNil(t, err)
+}
+
+func TestGCMMatchesStandardLibrary(t *testing.T) {
+	key := GenerateRandomAESKey()
+	block, _ := NewAESECBBlock(key)
+	gcm, _ := NewGCM(block, 16)
+	reference, _ := aes.NewCipher(key)
+
+	for _, size := range []int{0, 1, 15, 16, 17, 100} {
+		for _, nonceSize := range []int{8, 12, 16} {
+			stdGCM, _ := cipher.NewGCMWithNonceSize(reference, nonceSize)
+			nonce := GenerateRandomKey(nonceSize)
+			plainText, aad := GenerateRandomKey(size), GenerateRandomKey(size/2)
+			assert.Equal(t, stdGCM.Seal(nil, nonce, plainText, aad), gcm.Seal(nil, nonce, plainText, aad))
+		}
+	}
+}
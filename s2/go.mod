@@ -3,8 +3,9 @@ module s2
 go 1.18
 
 require (
+	github.com/forgoer/openssl v1.2.1
 	github.com/stretchr/testify v1.7.1
-	github.com/forgoer/openssl v1.2.1 
+	s1 v0.0.0
 )
 
 require (
@@ -12,3 +13,5 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
+
+replace s1 => ../s1
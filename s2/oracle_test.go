@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+Oracles
+
+The attacks in this set only get to talk to a system that holds the key: ask it to encrypt something, maybe to
+decrypt something, or just whether it accepts a ciphertext. Oracle is that conversation. The key lives inside
+the concrete oracle (c11, c12, c13, c14), so an attack written against Oracle cannot cheat by using it.
+
+*/
+
+// ErrOracleUnsupported is returned by oracles for the operations their system does not offer
+var ErrOracleUnsupported = errors.New("Operation not offered by this oracle")
+
+// Oracle is the attacker's view of a system that encrypts under a key it keeps to itself
+type Oracle interface {
+	// Encrypt returns the system's ciphertext for attacker-controlled input
+	Encrypt(input []byte) ([]byte, error)
+	// Decrypt returns what the system makes of cipherText
+	Decrypt(cipherText []byte) ([]byte, error)
+	// Validate returns nil if the system accepts cipherText
+	Validate(cipherText []byte) error
+}
+
+// unsupportedDecrypt can be embedded by oracles whose system only encrypts
+type unsupportedDecrypt struct{}
+
+func (unsupportedDecrypt) Decrypt([]byte) ([]byte, error) {
+	return nil, ErrOracleUnsupported
+}
+
+func (unsupportedDecrypt) Validate([]byte) error {
+	return ErrOracleUnsupported
+}
+
+func TestOraclesReportUnsupportedOperations(t *testing.T) {
+	oracles := []Oracle{NewJibberJabberOracle(), NewECBSuffixOracle(), NewProfileOracle(), NewECBRandomPrefixOracle()}
+	for _, oracle := range oracles {
+		cipherText, err := oracle.Encrypt([]byte("foo@bar.com"))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(cipherText)%16)
+	}
+
+	_, err := oracles[0].Decrypt(make([]byte, 16))
+	assert.ErrorIs(t, err, ErrOracleUnsupported)
+	assert.ErrorIs(t, oracles[0].Validate(make([]byte, 16)), ErrOracleUnsupported)
+	_, err = oracles[1].Decrypt(make([]byte, 16))
+	assert.ErrorIs(t, err, ErrOracleUnsupported)
+	// the byte-at-a-time system must not double as a padding oracle
+	assert.ErrorIs(t, oracles[1].Validate(make([]byte, 16)), ErrOracleUnsupported)
+}
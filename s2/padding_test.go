@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/cipher"
+	cryptorand "crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+
+Padding schemes
+
+PKCS#7 is not the only way to fill the last block. The block modes take a Padding,
+so the same ECB/CBC code can produce and accept any of these:
+
+* PKCS#7:        every padding byte holds the padding length         ... DD 04 04 04 04
+* ANSI X.923:    zeros, the last byte holds the padding length       ... DD 00 00 00 04
+* ISO/IEC 7816-4: a single 0x80 marker followed by zeros            ... DD 80 00 00 00
+* ISO 10126:     random bytes, the last byte holds the padding length ... DD 81 A6 23 04
+* Zero padding:  zeros only, and only when needed; data ending in zeros does not survive a round trip
+* No padding:    input must already be a multiple of the block size
+
+All schemes except zero and no padding always add at least one byte, so unpadding is unambiguous.
+
+*/
+
+type Padding interface {
+	Pad(plainText []byte, blockSize int) []byte
+	Unpad(plainText []byte, blockSize int) ([]byte, error)
+}
+
+var (
+	PKCS7Padding    Padding = pkcs7Padding{}
+	X923Padding     Padding = x923Padding{}
+	ISO7816Padding  Padding = iso7816Padding{}
+	ISO10126Padding Padding = iso10126Padding{}
+	ZeroPadding     Padding = zeroPadding{}
+	NoPadding       Padding = noPadding{}
+)
+
+// paddingLength is how much Pad adds; like PadPKCS7 it panics on a block size nothing can be padded to
+func paddingLength(plainText []byte, blockSize int) int {
+	if blockSize <= 0 {
+		panic(fmt.Sprintf("Pad: invalid block size %d", blockSize))
+	}
+	return blockSize - len(plainText)%blockSize
+}
+
+// lengthBytePadding is paddingLength for the schemes that store it in one byte (X.923, ISO 10126)
+func lengthBytePadding(plainText []byte, blockSize int) []byte {
+	if blockSize > 255 {
+		panic(fmt.Sprintf("Pad: block size %d does not fit the length byte", blockSize))
+	}
+	return make([]byte, paddingLength(plainText, blockSize))
+}
+
+// checkPaddedLength rejects input that cannot be the output of a padding scheme
+func checkPaddedLength(plainText []byte, blockSize int) error {
+	if blockSize <= 0 || len(plainText) == 0 || len(plainText)%blockSize != 0 {
+		return ErrInvalidPadding
+	}
+	return nil
+}
+
+// lengthByte reads and bounds-checks the padding length stored in the last byte (X.923, ISO 10126)
+func lengthByte(plainText []byte, blockSize int) (int, error) {
+	if err := checkPaddedLength(plainText, blockSize); err != nil {
+		return 0, err
+	}
+	padding := int(plainText[len(plainText)-1])
+	if padding == 0 || padding > blockSize {
+		return 0, ErrInvalidPadding
+	}
+	return padding, nil
+}
+
+type pkcs7Padding struct{}
+
+func (pkcs7Padding) Pad(plainText []byte, blockSize int) []byte {
+	return PadPKCS7Block(plainText, blockSize)
+}
+
+func (pkcs7Padding) Unpad(plainText []byte, blockSize int) ([]byte, error) {
+	return UnpadPKCS7(plainText, blockSize)
+}
+
+type x923Padding struct{}
+
+func (x923Padding) Pad(plainText []byte, blockSize int) []byte {
+	padding := lengthBytePadding(plainText, blockSize)
+	padding[len(padding)-1] = byte(len(padding))
+	return append(plainText, padding...)
+}
+
+func (x923Padding) Unpad(plainText []byte, blockSize int) ([]byte, error) {
+	padding, err := lengthByte(plainText, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range plainText[len(plainText)-padding : len(plainText)-1] {
+		if b != 0 {
+			return nil, ErrInvalidPadding
+		}
+	}
+	return plainText[:len(plainText)-padding], nil
+}
+
+type iso7816Padding struct{}
+
+func (iso7816Padding) Pad(plainText []byte, blockSize int) []byte {
+	padding := make([]byte, paddingLength(plainText, blockSize))
+	padding[0] = 0x80
+	return append(plainText, padding...)
+}
+
+func (iso7816Padding) Unpad(plainText []byte, blockSize int) ([]byte, error) {
+	if err := checkPaddedLength(plainText, blockSize); err != nil {
+		return nil, err
+	}
+	for i := len(plainText) - 1; i >= len(plainText)-blockSize; i-- {
+		switch plainText[i] {
+		case 0x80:
+			return plainText[:i], nil
+		case 0x00:
+		default:
+			return nil, ErrInvalidPadding
+		}
+	}
+	return nil, ErrInvalidPadding
+}
+
+type iso10126Padding struct{}
+
+func (iso10126Padding) Pad(plainText []byte, blockSize int) []byte {
+	padding := lengthBytePadding(plainText, blockSize)
+	cryptorand.Read(padding)
+	padding[len(padding)-1] = byte(len(padding))
+	return append(plainText, padding...)
+}
+
+func (iso10126Padding) Unpad(plainText []byte, blockSize int) ([]byte, error) {
+	padding, err := lengthByte(plainText, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	return plainText[:len(plainText)-padding], nil
+}
+
+type zeroPadding struct{}
+
+func (zeroPadding) Pad(plainText []byte, blockSize int) []byte {
+	padding := paddingLength(plainText, blockSize)
+	if padding == blockSize {
+		return plainText
+	}
+	return append(plainText, make([]byte, padding)...)
+}
+
+func (zeroPadding) Unpad(plainText []byte, blockSize int) ([]byte, error) {
+	if blockSize <= 0 || len(plainText)%blockSize != 0 {
+		return nil, ErrInvalidPadding
+	}
+	end := len(plainText)
+	for end > 0 && end > len(plainText)-blockSize && plainText[end-1] == 0 {
+		end--
+	}
+	return plainText[:end], nil
+}
+
+type noPadding struct{}
+
+func (noPadding) Pad(plainText []byte, blockSize int) []byte {
+	return plainText
+}
+
+func (noPadding) Unpad(plainText []byte, blockSize int) ([]byte, error) {
+	if blockSize <= 0 || len(plainText)%blockSize != 0 {
+		return nil, ErrInvalidPadding
+	}
+	return plainText, nil
+}
+
+var paddings = map[string]Padding{
+	"PKCS#7":     PKCS7Padding,
+	"ANSI X.923": X923Padding,
+	"ISO 7816-4": ISO7816Padding,
+	"ISO 10126":  ISO10126Padding,
+	"zero":       ZeroPadding,
+	"none":       NoPadding,
+}
+
+func TestPaddingExamples(t *testing.T) {
+	assert.Equal(t, []byte("YELLOW SUBMA\x04\x04\x04\x04"), PKCS7Padding.Pad([]byte("YELLOW SUBMA"), 16))
+	assert.Equal(t, []byte("YELLOW SUBMA\x00\x00\x00\x04"), X923Padding.Pad([]byte("YELLOW SUBMA"), 16))
+	assert.Equal(t, []byte("YELLOW SUBMA\x80\x00\x00\x00"), ISO7816Padding.Pad([]byte("YELLOW SUBMA"), 16))
+	assert.Equal(t, []byte("YELLOW SUBMA\x00\x00\x00\x00"), ZeroPadding.Pad([]byte("YELLOW SUBMA"), 16))
+	assert.Equal(t, []byte("YELLOW SUBMA"), NoPadding.Pad([]byte("YELLOW SUBMA"), 16))
+
+	padded := ISO10126Padding.Pad([]byte("YELLOW SUBMA"), 16)
+	assert.Equal(t, 16, len(padded))
+	assert.Equal(t, byte(4), padded[15])
+}
+
+func TestPaddingRoundTrip(t *testing.T) {
+	const text = "Rollin' in my 5.0 With my rag-top down"
+	for name, padding := range paddings {
+		for _, blockSize := range []int{8, 16, 32} {
+			for size := 1; size <= len(text); size++ {
+				plainText := []byte(text[:size])
+				if padding == NoPadding && size%blockSize != 0 {
+					continue
+				}
+				padded := padding.Pad(append([]byte{}, plainText...), blockSize)
+				assert.Equal(t, 0, len(padded)%blockSize, name)
+				unpadded, err := padding.Unpad(padded, blockSize)
+				assert.Nil(t, err, name)
+				assert.Equal(t, plainText, unpadded, name)
+			}
+		}
+	}
+}
+
+func TestPaddingMalformed(t *testing.T) {
+	malformed := map[Padding][]string{
+		PKCS7Padding:    {"YELLOW SUBMA\x01\x02\x03\x04", "YELLOW SUBMA\x00", "YELLOW SUBMARINE\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"},
+		X923Padding:     {"YELLOW SUBMA\x00\x01\x00\x04", "YELLOW SUBMA\x00\x00\x00\x00", "YELLOW SUBMA\x00\x00\x00\x11", "YELLOW"},
+		ISO7816Padding:  {"YELLOW SUBMA\x80\x00\x01\x00", "YELLOW SUBMARINE", "YELLOW SUBMARINE\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"},
+		ISO10126Padding: {"YELLOW SUBMA\xaa\xbb\xcc\x00", "YELLOW SUBMA\xaa\xbb\xcc\x11", "YELLOW SUBMA\x01"},
+		ZeroPadding:     {"YELLOW SUBMA\x00"},
+		NoPadding:       {"YELLOW SUBMA"},
+	}
+	for padding, inputs := range malformed {
+		for _, input := range inputs {
+			_, err := padding.Unpad([]byte(input), 16)
+			assert.Equal(t, ErrInvalidPadding, err, "%T %q", padding, input)
+		}
+		_, err := padding.Unpad([]byte{}, 16)
+		if padding != ZeroPadding && padding != NoPadding {
+			assert.Equal(t, ErrInvalidPadding, err, "%T empty", padding)
+		}
+	}
+}
+
+func TestPaddingInvalidBlockSizes(t *testing.T) {
+	for name, padding := range paddings {
+		for _, blockSize := range []int{0, -16} {
+			_, err := padding.Unpad([]byte("YELLOW SUBMARINE"), blockSize)
+			assert.Equal(t, ErrInvalidPadding, err, "%s block size %d", name, blockSize)
+			if padding != NoPadding {
+				assert.Panics(t, func() { padding.Pad([]byte("YELLOW SUBMARINE"), blockSize) }, name)
+			}
+		}
+	}
+	// the length byte cannot count past 255
+	assert.Panics(t, func() { X923Padding.Pad([]byte("YELLOW"), 256) })
+	assert.Panics(t, func() { ISO10126Padding.Pad([]byte("YELLOW"), 256) })
+	assert.Equal(t, 300, len(ISO7816Padding.Pad([]byte("YELLOW"), 300)))
+}
+
+func TestModesWithEveryPadding(t *testing.T) {
+	plainText := []byte("Hello, World!!!! and more")
+	aligned := []byte("Hello, World!!!!0123456789ABCDEF")
+	aesBlock, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	desBlock, _ := NewDESCipher([]byte("SUBMARIN"))
+
+	for name, padding := range paddings {
+		for _, block := range []cipher.Block{aesBlock, desBlock} {
+			input := plainText
+			if padding == NoPadding {
+				input = aligned
+			}
+			IV := make([]byte, block.BlockSize())
+
+			decrypted, err := DecryptCBC(block, EncryptCBC(block, input, IV, padding), IV, padding)
+			assert.Nil(t, err, name)
+			assert.Equal(t, input, decrypted, name)
+
+			decrypted, err = DecryptECB(block, EncryptECB(block, input, padding), padding)
+			assert.Nil(t, err, name)
+			assert.Equal(t, input, decrypted, name)
+		}
+	}
+}
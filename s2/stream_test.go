@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"s2/blockmode"
+)
+
+/*
+
+Streaming block modes
+
+EncryptCBC and DecryptCBC want the whole message in memory. For files and network streams the
+modes are split into two parts:
+  - a cipher.BlockMode that keeps the chaining state between calls (ECB and CBC from blockmode), and
+  - an io.WriteCloser that encrypts full blocks as they arrive and pads the rest on Close, and
+    an io.Reader that always withholds the last block until EOF, because only then is it known
+    to be the one holding the padding.
+
+CTR needs none of this: NewCTR returns a cipher.Stream, which plugs into cipher.StreamReader and
+cipher.StreamWriter. CFB and OFB (cfb_ofb_test.go) are still functions over a whole message.
+
+*/
+
+// streamChunkSize is how much ciphertext the reader asks for at once
+const streamChunkSize = 32 * 1024
+
+type encryptingWriter struct {
+	w       io.Writer
+	mode    cipher.BlockMode
+	padding Padding
+	pending []byte
+	closed  bool
+	err     error
+}
+
+// NewEncryptingWriter encrypts everything written to it with mode and writes the ciphertext to w.
+// Close pads and flushes the last block, and closes w if it is an io.Closer.
+func NewEncryptingWriter(w io.Writer, mode cipher.BlockMode, padding Padding) io.WriteCloser {
+	return &encryptingWriter{w: w, mode: mode, padding: padding}
+}
+
+// Write reports how many bytes of p it took: all of them, unless w fails. Then it is the part of p whose
+// ciphertext reached w, and the writer stays broken, because the chaining state has moved past what w holds.
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("Write after Close")
+	}
+	if e.err != nil {
+		return 0, e.err
+	}
+	blockSize := e.mode.BlockSize()
+	buffered := len(e.pending)
+	e.pending = append(e.pending, p...)
+	ready := len(e.pending) / blockSize * blockSize
+	if ready > 0 {
+		cipherText := make([]byte, ready)
+		e.mode.CryptBlocks(cipherText, e.pending[:ready])
+		if n, err := e.w.Write(cipherText); err != nil {
+			e.err = err
+			written := n - buffered
+			if written < 0 {
+				written = 0
+			}
+			return written, err
+		}
+		e.pending = append(e.pending[:0], e.pending[ready:]...)
+	}
+	return len(p), nil
+}
+
+func (e *encryptingWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	if e.err != nil {
+		return e.err
+	}
+	blockSize := e.mode.BlockSize()
+	last := e.padding.Pad(e.pending, blockSize)
+	if len(last)%blockSize != 0 {
+		return errors.New("Close: input not full blocks")
+	}
+	e.mode.CryptBlocks(last, last)
+	if _, err := e.w.Write(last); err != nil {
+		return err
+	}
+	if closer, ok := e.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type decryptingReader struct {
+	r       io.Reader
+	mode    cipher.BlockMode
+	padding Padding
+	in      []byte
+	out     []byte
+	err     error
+}
+
+// NewDecryptingReader decrypts the ciphertext read from r with mode.
+// The last block is held back until r reports EOF, then its padding is checked and stripped.
+func NewDecryptingReader(r io.Reader, mode cipher.BlockMode, padding Padding) io.Reader {
+	return &decryptingReader{r: r, mode: mode, padding: padding}
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	blockSize := d.mode.BlockSize()
+	for len(d.out) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+
+		start := len(d.in)
+		d.in = append(d.in, make([]byte, streamChunkSize)...)
+		n, err := d.r.Read(d.in[start:])
+		d.in = d.in[:start+n]
+
+		switch {
+		case err == io.EOF:
+			if len(d.in)%blockSize != 0 {
+				d.err = io.ErrUnexpectedEOF
+				continue
+			}
+			d.mode.CryptBlocks(d.in, d.in)
+			plainText, err := d.padding.Unpad(d.in, blockSize)
+			if err != nil {
+				d.err = err
+				continue
+			}
+			d.out, d.in, d.err = plainText, nil, io.EOF
+		case err != nil:
+			d.err = err
+		case len(d.in) > 0:
+			// release every block but the last one, which might be the padding block
+			ready := (len(d.in) - 1) / blockSize * blockSize
+			d.out = make([]byte, ready)
+			d.mode.CryptBlocks(d.out, d.in[:ready])
+			d.in = append(d.in[:0], d.in[ready:]...)
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// closeRecorder lets the tests check whether Close reached the underlying writer
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestEncryptingWriterMatchesEncryptCBC(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	IV := GenerateRandomKey(16)
+	plainText := GenerateRandomKey(1000)
+
+	for _, step := range []int{1, 7, 16, 33, 1000} {
+		var out closeRecorder
+		w := NewEncryptingWriter(&out, blockmode.NewCBCEncrypter(block, IV), PKCS7Padding)
+		for i := 0; i < len(plainText); i += step {
+			end := i + step
+			if end > len(plainText) {
+				end = len(plainText)
+			}
+			n, err := w.Write(plainText[i:end])
+			assert.Nil(t, err)
+			assert.Equal(t, end-i, n)
+		}
+		assert.Nil(t, w.Close())
+		assert.True(t, out.closed)
+		assert.Equal(t, EncryptCBC(block, plainText, IV, PKCS7Padding), out.Bytes())
+
+		_, err := w.Write([]byte("late"))
+		assert.NotNil(t, err)
+	}
+}
+
+// failingWriter takes limit bytes, then fails
+type failingWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.Len()+len(p) <= f.limit {
+		return f.Buffer.Write(p)
+	}
+	n, _ := f.Buffer.Write(p[:f.limit-f.Len()])
+	return n, errors.New("disk full")
+}
+
+func TestEncryptingWriterReportsConsumedBytes(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	out := &failingWriter{limit: 40}
+	w := NewEncryptingWriter(out, blockmode.NewCBCEncrypter(block, make([]byte, 16)), PKCS7Padding)
+
+	n, err := w.Write(make([]byte, 10))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	// 10 buffered + 50 new make 3 blocks; the writer takes 40 bytes of them, 30 of which came from this call
+	n, err = w.Write(make([]byte, 50))
+	assert.NotNil(t, err)
+	assert.Equal(t, 30, n)
+
+	n, err = w.Write(make([]byte, 16))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, n)
+	assert.NotNil(t, w.Close())
+}
+
+func TestDecryptingReaderWithholdsLastBlock(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	IV := GenerateRandomKey(16)
+
+	for _, size := range []int{0, 1, 15, 16, 17, 100, 3 * streamChunkSize} {
+		plainText := GenerateRandomKey(size)
+		cipherText := EncryptCBC(block, plainText, IV, PKCS7Padding)
+
+		readers := map[string]io.Reader{
+			"whole":     bytes.NewReader(cipherText),
+			"one byte":  iotest.OneByteReader(bytes.NewReader(cipherText)),
+			"half":      iotest.HalfReader(bytes.NewReader(cipherText)),
+			"data+EOF":  iotest.DataErrReader(bytes.NewReader(cipherText)),
+			"one, half": iotest.OneByteReader(iotest.HalfReader(bytes.NewReader(cipherText))),
+		}
+		for name, r := range readers {
+			decrypted, err := io.ReadAll(NewDecryptingReader(r, blockmode.NewCBCDecrypter(block, IV), PKCS7Padding))
+			assert.Nil(t, err, name)
+			assert.Equal(t, len(plainText), len(decrypted), name)
+			assert.True(t, bytes.Equal(plainText, decrypted), name)
+		}
+	}
+}
+
+func TestDecryptingReaderErrors(t *testing.T) {
+	block, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	IV := make([]byte, 16)
+	cipherText := EncryptCBC(block, []byte("Cooking MC's like a pound of bacon"), IV, PKCS7Padding)
+
+	_, err := io.ReadAll(NewDecryptingReader(bytes.NewReader(cipherText[:40]), blockmode.NewCBCDecrypter(block, IV), PKCS7Padding))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// dropping the last block leaves a stream that ends in garbage instead of padding
+	_, err = io.ReadAll(NewDecryptingReader(bytes.NewReader(cipherText[:32]), blockmode.NewCBCDecrypter(block, IV), PKCS7Padding))
+	assert.ErrorIs(t, err, ErrInvalidPadding)
+
+	failing := iotest.TimeoutReader(bytes.NewReader(cipherText))
+	_, err = io.ReadAll(NewDecryptingReader(failing, blockmode.NewCBCDecrypter(block, IV), PKCS7Padding))
+	assert.Equal(t, iotest.ErrTimeout, err)
+
+	w := NewEncryptingWriter(&bytes.Buffer{}, blockmode.NewCBCEncrypter(block, IV), NoPadding)
+	w.Write([]byte("not a block"))
+	assert.NotNil(t, w.Close())
+}
+
+func TestStreamingOtherModes(t *testing.T) {
+	aesBlock, _ := NewAESECBBlock([]byte("YELLOW SUBMARINE"))
+	desBlock, _ := NewDESCipher([]byte("SUBMARIN"))
+	plainText := []byte("I go crazy when I hear a cymbal, and a high hat with a souped up tempo")
+
+	// ECB and CBC on 8-byte blocks, through the same writer and reader
+	for _, block := range []cipher.Block{aesBlock, desBlock} {
+		var out bytes.Buffer
+		w := NewEncryptingWriter(&out, blockmode.NewECBEncrypter(block), X923Padding)
+		w.Write(plainText)
+		assert.Nil(t, w.Close())
+		assert.Equal(t, EncryptECB(block, plainText, X923Padding), out.Bytes())
+
+		decrypted, err := io.ReadAll(NewDecryptingReader(&out, blockmode.NewECBDecrypter(block), X923Padding))
+		assert.Nil(t, err)
+		assert.Equal(t, plainText, decrypted)
+	}
+
+	// CTR is already a cipher.Stream
+	IV := GenerateRandomKey(16)
+	var out bytes.Buffer
+	w := cipher.StreamWriter{S: NewCTR(aesBlock, IV, CounterBE128), W: &out}
+	w.Write(plainText)
+	assert.Equal(t, CTRCrypt(aesBlock, IV, CounterBE128, plainText), out.Bytes())
+	decrypted, err := io.ReadAll(cipher.StreamReader{S: NewCTR(aesBlock, IV, CounterBE128), R: &out})
+	assert.Nil(t, err)
+	assert.Equal(t, plainText, decrypted)
+}